@@ -0,0 +1,180 @@
+package address
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Shelley address types, from the high nibble of the header byte.
+const (
+	TypeBaseKeyKey       = 0
+	TypeBaseScriptKey    = 1
+	TypeBaseKeyScript    = 2
+	TypeBaseScriptScript = 3
+	TypePointerKey       = 4
+	TypePointerScript    = 5
+	TypeEnterpriseKey    = 6
+	TypeEnterpriseScript = 7
+	TypeByron            = 8
+	TypeRewardKey        = 14
+	TypeRewardScript     = 15
+)
+
+// Network tags, from the low nibble of the header byte.
+const (
+	NetworkTestnet = 0
+	NetworkMainnet = 1
+)
+
+// Address kinds, see Address.Kind.
+const (
+	KindBase       = "base"
+	KindPointer    = "pointer"
+	KindEnterprise = "enterprise"
+	KindReward     = "reward"
+	KindByron      = "byron"
+)
+
+const credentialLength = 28
+
+// Address is a decoded Cardano address.
+type Address struct {
+	Type    byte
+	Network byte
+
+	// ProtocolMagic is only set on Byron addresses.
+	ProtocolMagic uint64
+
+	Payment []byte
+	Stake   []byte
+
+	Bytes []byte
+}
+
+// Decode decodes a bech32 Shelley address or a base58 Byron address.
+func Decode(addr string) (a Address, err error) {
+	if strings.HasPrefix(addr, "addr") || strings.HasPrefix(addr, "stake") {
+		return decodeShelley(addr)
+	}
+
+	if a, err = decodeByron(addr); err != nil {
+		return a, fmt.Errorf("invalid address: %w", err)
+	}
+
+	return a, nil
+}
+
+func decodeShelley(addr string) (a Address, err error) {
+	hrp, data, err := decodeBech32(addr)
+	if err != nil {
+		return a, fmt.Errorf("invalid address: %w", err)
+	}
+
+	if len(data) == 0 {
+		return a, fmt.Errorf("invalid address: empty")
+	}
+
+	a = Address{
+		Type:    data[0] >> 4,
+		Network: data[0] & 0x0f,
+		Bytes:   data,
+	}
+
+	body := data[1:]
+
+	switch a.Type {
+	case TypeBaseKeyKey, TypeBaseScriptKey, TypeBaseKeyScript, TypeBaseScriptScript:
+		if len(body) != 2*credentialLength {
+			return a, fmt.Errorf("invalid address: base address length %d", len(data))
+		}
+
+		a.Payment = body[:credentialLength]
+		a.Stake = body[credentialLength:]
+	case TypePointerKey, TypePointerScript, TypeEnterpriseKey, TypeEnterpriseScript:
+		if len(body) < credentialLength {
+			return a, fmt.Errorf("invalid address: length %d", len(data))
+		}
+
+		a.Payment = body[:credentialLength]
+	case TypeRewardKey, TypeRewardScript:
+		if len(body) != credentialLength {
+			return a, fmt.Errorf("invalid address: reward address length %d", len(data))
+		}
+
+		a.Stake = body
+	default:
+		return a, fmt.Errorf("invalid address: unknown type %d", a.Type)
+	}
+
+	if want := expectedPrefix(a); hrp != want {
+		return a, fmt.Errorf("invalid address: prefix %q doesn't match its header, want %q", hrp, want)
+	}
+
+	return a, nil
+}
+
+func expectedPrefix(a Address) string {
+	prefix := "addr"
+	if a.Kind() == KindReward {
+		prefix = "stake"
+	}
+
+	if a.Network != NetworkMainnet {
+		prefix += "_test"
+	}
+
+	return prefix
+}
+
+// Kind classifies the address as base, pointer, enterprise, reward or byron.
+func (a Address) Kind() string {
+	switch a.Type {
+	case TypeBaseKeyKey, TypeBaseScriptKey, TypeBaseKeyScript, TypeBaseScriptScript:
+		return KindBase
+	case TypePointerKey, TypePointerScript:
+		return KindPointer
+	case TypeEnterpriseKey, TypeEnterpriseScript:
+		return KindEnterprise
+	case TypeRewardKey, TypeRewardScript:
+		return KindReward
+	}
+
+	return KindByron
+}
+
+// IsScript reports whether funds at the address are locked by a script.
+func (a Address) IsScript() bool {
+	switch a.Type {
+	case TypeBaseScriptKey, TypeBaseScriptScript, TypePointerScript, TypeEnterpriseScript, TypeRewardScript:
+		return true
+	}
+
+	return false
+}
+
+// CheckNetwork checks the address against a cardano-wallet network ID,
+// "mainnet" or "testnet".
+func (a Address) CheckNetwork(networkID string) error {
+	want := byte(NetworkTestnet)
+	if networkID == "mainnet" {
+		want = NetworkMainnet
+	}
+
+	if a.Network != want {
+		return fmt.Errorf("address is not on %s", networkID)
+	}
+
+	return nil
+}
+
+// OwnerCredential returns the hex credential that identifies who controls
+// the address: the stake credential when there is one, otherwise the
+// payment credential.
+func (a Address) OwnerCredential() string {
+	if len(a.Stake) > 0 {
+		return hex.EncodeToString(a.Stake)
+	}
+
+	return hex.EncodeToString(a.Payment)
+}
@@ -0,0 +1,364 @@
+package address
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// Credentials of the CIP-19 test vectors.
+const (
+	testPaymentKey = "9493315cd92eb5d8c4304e67b7e16ae36d61d34502694657811a2c8e"
+	testStakeKey   = "337b62cfff6403a06a3acbc34f8c46003c69fe79a3628cefa9c47251"
+	testScript     = "c37b1b5dc0669f1d3c61a6fddb2e8fde96be87b881c60bce8e8d542f"
+)
+
+// encodeBech32 encodes data under the prefix, to build addresses the
+// vectors don't cover.
+func encodeBech32(t *testing.T, hrp string, data []byte) string {
+	t.Helper()
+
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	polymod := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(polymod>>uint(5*(5-i))&31))
+	}
+
+	var s strings.Builder
+	s.WriteString(hrp + "1")
+	for _, v := range values {
+		s.WriteByte(bech32Charset[v])
+	}
+
+	return s.String()
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		kind    string
+		network byte
+		script  bool
+		payment string
+		stake   string
+		magic   uint64
+	}{
+		// CIP-19 mainnet
+		{
+			name: "mainnet base key key",
+			addr: "addr1qx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzer3n0d3vllmyqwsx5wktcd8cc3sq835lu7drv2xwl2wywfgse35a3x",
+			kind: KindBase, network: NetworkMainnet, payment: testPaymentKey, stake: testStakeKey,
+		},
+		{
+			name: "mainnet base script key",
+			addr: "addr1z8phkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gten0d3vllmyqwsx5wktcd8cc3sq835lu7drv2xwl2wywfgs9yc0hh",
+			kind: KindBase, network: NetworkMainnet, script: true, payment: testScript, stake: testStakeKey,
+		},
+		{
+			name: "mainnet base key script",
+			addr: "addr1yx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzerkr0vd4msrxnuwnccdxlhdjar77j6lg0wypcc9uar5d2shs2z78ve",
+			kind: KindBase, network: NetworkMainnet, payment: testPaymentKey, stake: testScript,
+		},
+		{
+			name: "mainnet base script script",
+			addr: "addr1x8phkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gt7r0vd4msrxnuwnccdxlhdjar77j6lg0wypcc9uar5d2shskhj42g",
+			kind: KindBase, network: NetworkMainnet, script: true, payment: testScript, stake: testScript,
+		},
+		{
+			name: "mainnet pointer key",
+			addr: "addr1gx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzer5pnz75xxcrzqf96k",
+			kind: KindPointer, network: NetworkMainnet, payment: testPaymentKey,
+		},
+		{
+			name: "mainnet pointer script",
+			addr: "addr128phkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gtupnz75xxcrtw79hu",
+			kind: KindPointer, network: NetworkMainnet, script: true, payment: testScript,
+		},
+		{
+			name: "mainnet enterprise key",
+			addr: "addr1vx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzers66hrl8",
+			kind: KindEnterprise, network: NetworkMainnet, payment: testPaymentKey,
+		},
+		{
+			name: "mainnet enterprise script",
+			addr: "addr1w8phkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gtcyjy7wx",
+			kind: KindEnterprise, network: NetworkMainnet, script: true, payment: testScript,
+		},
+		{
+			name: "mainnet stake key",
+			addr: "stake1uyehkck0lajq8gr28t9uxnuvgcqrc6070x3k9r8048z8y5gh6ffgw",
+			kind: KindReward, network: NetworkMainnet, stake: testStakeKey,
+		},
+		{
+			name: "mainnet stake script",
+			addr: "stake178phkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gtcccycj5",
+			kind: KindReward, network: NetworkMainnet, script: true, stake: testScript,
+		},
+
+		// CIP-19 testnet
+		{
+			name: "testnet base key key",
+			addr: "addr_test1qz2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzer3n0d3vllmyqwsx5wktcd8cc3sq835lu7drv2xwl2wywfgs68faae",
+			kind: KindBase, network: NetworkTestnet, payment: testPaymentKey, stake: testStakeKey,
+		},
+		{
+			name: "testnet base script key",
+			addr: "addr_test1zrphkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gten0d3vllmyqwsx5wktcd8cc3sq835lu7drv2xwl2wywfgsxj90mg",
+			kind: KindBase, network: NetworkTestnet, script: true, payment: testScript, stake: testStakeKey,
+		},
+		{
+			name: "testnet pointer key",
+			addr: "addr_test1gz2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzer5pnz75xxcrdw5vky",
+			kind: KindPointer, network: NetworkTestnet, payment: testPaymentKey,
+		},
+		{
+			name: "testnet enterprise key",
+			addr: "addr_test1vz2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzerspjrlsz",
+			kind: KindEnterprise, network: NetworkTestnet, payment: testPaymentKey,
+		},
+		{
+			name: "testnet enterprise script",
+			addr: "addr_test1wrphkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gtcl6szpr",
+			kind: KindEnterprise, network: NetworkTestnet, script: true, payment: testScript,
+		},
+		{
+			name: "testnet stake key",
+			addr: "stake_test1uqehkck0lajq8gr28t9uxnuvgcqrc6070x3k9r8048z8y5gssrtvn",
+			kind: KindReward, network: NetworkTestnet, stake: testStakeKey,
+		},
+		{
+			name: "testnet stake script",
+			addr: "stake_test17rphkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gtcljw6kf",
+			kind: KindReward, network: NetworkTestnet, script: true, stake: testScript,
+		},
+
+		// Byron
+		{
+			name: "mainnet byron",
+			addr: "DdzFFzCqrhsw3prhfMFDNFowbzUku3QmrMwarfjUbWXRisodn97R436SHc1rimp4MhPNmbdYb1aTdqtGSJixMVMi5MkArDQJ6Sc1n3Ez",
+			kind: KindByron, network: NetworkMainnet, magic: mainnetProtocolMagic,
+			payment: "83ff43ed8337e0b719c5c2fc4ec75de4c70aa4865c0b269fb29bb9f6",
+		},
+		{
+			name: "testnet byron",
+			addr: "37btjrVyb4KDXBNC4haBVPCrro8AQPHwvCMp3RFhhSVWwfFmZ6wwzSK6JK1hY6wHNmtrpTf1kdbva8TCneM2YsiXT7mrzT21EacHnPpz5YyUdj64na",
+			kind: KindByron, network: NetworkTestnet, magic: 1097911063,
+			payment: "7e9ee4a9527dea9091e2d580edd6716888c42f75d96276290f98fe0b",
+		},
+		{
+			name: "testnet byron without derivation path",
+			addr: "2cWKMJemoBaipzQe9BArYdo2iPUfJQdZAjm4iCzDA1AfNxJSTgm9FZQTmFCYhKkeYrede",
+			kind: KindByron, network: NetworkTestnet, magic: 1097911063,
+			payment: "65d6bdf13c6bf6da3b7d3df5b6caf6bb35f488fcd093b81de482df87",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := Decode(tt.addr)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+
+			if a.Kind() != tt.kind {
+				t.Errorf("Kind = %s, want %s", a.Kind(), tt.kind)
+			}
+
+			if a.Network != tt.network {
+				t.Errorf("Network = %d, want %d", a.Network, tt.network)
+			}
+
+			if a.IsScript() != tt.script {
+				t.Errorf("IsScript = %v, want %v", a.IsScript(), tt.script)
+			}
+
+			if got := hex.EncodeToString(a.Payment); got != tt.payment {
+				t.Errorf("Payment = %s, want %s", got, tt.payment)
+			}
+
+			if got := hex.EncodeToString(a.Stake); got != tt.stake {
+				t.Errorf("Stake = %s, want %s", got, tt.stake)
+			}
+
+			if a.ProtocolMagic != tt.magic {
+				t.Errorf("ProtocolMagic = %d, want %d", a.ProtocolMagic, tt.magic)
+			}
+
+			owner := tt.stake
+			if owner == "" {
+				owner = tt.payment
+			}
+
+			if got := a.OwnerCredential(); got != owner {
+				t.Errorf("OwnerCredential = %s, want %s", got, owner)
+			}
+
+			// cardano-wallet network IDs: mainnet, or testnet for the rest
+			mainnet, testnet := a.CheckNetwork("mainnet"), a.CheckNetwork("testnet")
+			if tt.network == NetworkMainnet && (mainnet != nil || testnet == nil) {
+				t.Errorf("CheckNetwork: mainnet %v, testnet %v, want a mainnet address", mainnet, testnet)
+			}
+
+			if tt.network == NetworkTestnet && (mainnet == nil || testnet != nil) {
+				t.Errorf("CheckNetwork: mainnet %v, testnet %v, want a testnet address", mainnet, testnet)
+			}
+		})
+	}
+}
+
+// flipLast replaces the last character of s with another of the alphabet.
+func flipLast(s, alphabet string) string {
+	last := strings.IndexByte(alphabet, s[len(s)-1])
+	return s[:len(s)-1] + string(alphabet[(last+1)%len(alphabet)])
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	const (
+		base    = "addr1qx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzer3n0d3vllmyqwsx5wktcd8cc3sq835lu7drv2xwl2wywfgse35a3x"
+		stake   = "stake1uyehkck0lajq8gr28t9uxnuvgcqrc6070x3k9r8048z8y5gh6ffgw"
+		testnet = "addr_test1vz2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzerspjrlsz"
+		byron   = "DdzFFzCqrhsw3prhfMFDNFowbzUku3QmrMwarfjUbWXRisodn97R436SHc1rimp4MhPNmbdYb1aTdqtGSJixMVMi5MkArDQJ6Sc1n3Ez"
+	)
+
+	key := mustHex(t, testPaymentKey)
+	stakeKey := mustHex(t, testStakeKey)
+
+	header := func(addrType, network byte) byte {
+		return addrType<<4 | network
+	}
+
+	tests := []struct {
+		name string
+		addr func(t *testing.T) string
+	}{
+		{name: "empty", addr: func(*testing.T) string { return "" }},
+		{name: "base bad checksum", addr: func(*testing.T) string { return flipLast(base, bech32Charset) }},
+		{name: "stake bad checksum", addr: func(*testing.T) string { return flipLast(stake, bech32Charset) }},
+		{name: "testnet bad checksum", addr: func(*testing.T) string { return flipLast(testnet, bech32Charset) }},
+		{name: "mixed case", addr: func(*testing.T) string { return "A" + base[1:] }},
+		{name: "not in the charset", addr: func(*testing.T) string { return base[:20] + "b" + base[21:] }},
+		{name: "byron bad checksum", addr: func(*testing.T) string { return flipLast(byron, base58Alphabet) }},
+		{name: "not base58", addr: func(*testing.T) string { return "0OIl" + byron[4:] }},
+		{
+			name: "mainnet header with testnet prefix",
+			addr: func(t *testing.T) string {
+				return encodeBech32(t, "addr_test", append([]byte{header(TypeEnterpriseKey, NetworkMainnet)}, key...))
+			},
+		},
+		{
+			name: "testnet header with mainnet prefix",
+			addr: func(t *testing.T) string {
+				return encodeBech32(t, "addr", append([]byte{header(TypeEnterpriseKey, NetworkTestnet)}, key...))
+			},
+		},
+		{
+			name: "stake header with addr prefix",
+			addr: func(t *testing.T) string {
+				return encodeBech32(t, "addr", append([]byte{header(TypeRewardKey, NetworkMainnet)}, stakeKey...))
+			},
+		},
+		{
+			name: "base header with stake prefix",
+			addr: func(t *testing.T) string {
+				return encodeBech32(t, "stake", append(append([]byte{header(TypeBaseKeyKey, NetworkMainnet)}, key...), stakeKey...))
+			},
+		},
+		{
+			name: "short base",
+			addr: func(t *testing.T) string {
+				return encodeBech32(t, "addr", append([]byte{header(TypeBaseKeyKey, NetworkMainnet)}, key...))
+			},
+		},
+		{
+			name: "long stake",
+			addr: func(t *testing.T) string {
+				return encodeBech32(t, "stake", append(append([]byte{header(TypeRewardKey, NetworkMainnet)}, stakeKey...), 0))
+			},
+		},
+		{
+			name: "short enterprise",
+			addr: func(t *testing.T) string {
+				return encodeBech32(t, "addr", append([]byte{header(TypeEnterpriseKey, NetworkMainnet)}, key[:20]...))
+			},
+		},
+		{
+			name: "unknown type",
+			addr: func(t *testing.T) string {
+				return encodeBech32(t, "addr", append([]byte{header(9, NetworkMainnet)}, key...))
+			},
+		},
+		{
+			name: "byron header in bech32",
+			addr: func(t *testing.T) string {
+				return encodeBech32(t, "addr", append([]byte{header(TypeByron, NetworkMainnet)}, key...))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := tt.addr(t)
+
+			if a, err := Decode(addr); err == nil {
+				t.Fatalf("Decode(%q) = %s address, want an error", addr, a.Kind())
+			}
+		})
+	}
+}
+
+// The helper builds what the decoder reads back, so the cases above fail for
+// the reason they name.
+func TestEncodeBech32(t *testing.T) {
+	key := mustHex(t, testPaymentKey)
+
+	addr := encodeBech32(t, "addr_test", append([]byte{TypeEnterpriseKey<<4 | NetworkTestnet}, key...))
+	if want := "addr_test1vz2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzerspjrlsz"; addr != want {
+		t.Fatalf("encodeBech32 = %s, want %s", addr, want)
+	}
+}
+
+func TestCheckNetwork(t *testing.T) {
+	tests := []struct {
+		addr      string
+		networkID string
+		wantErr   bool
+	}{
+		{addr: "addr1vx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzers66hrl8", networkID: "mainnet"},
+		{addr: "addr1vx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzers66hrl8", networkID: "testnet", wantErr: true},
+		{addr: "addr_test1vz2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzerspjrlsz", networkID: "testnet"},
+		{addr: "addr_test1vz2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzerspjrlsz", networkID: "preprod"},
+		{addr: "addr_test1vz2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzerspjrlsz", networkID: "mainnet", wantErr: true},
+		{addr: "stake_test1uqehkck0lajq8gr28t9uxnuvgcqrc6070x3k9r8048z8y5gssrtvn", networkID: "mainnet", wantErr: true},
+		{addr: "stake1uyehkck0lajq8gr28t9uxnuvgcqrc6070x3k9r8048z8y5gh6ffgw", networkID: "preview", wantErr: true},
+		{addr: "DdzFFzCqrhsw3prhfMFDNFowbzUku3QmrMwarfjUbWXRisodn97R436SHc1rimp4MhPNmbdYb1aTdqtGSJixMVMi5MkArDQJ6Sc1n3Ez", networkID: "testnet", wantErr: true},
+		{addr: "37btjrVyb4KDXBNC4haBVPCrro8AQPHwvCMp3RFhhSVWwfFmZ6wwzSK6JK1hY6wHNmtrpTf1kdbva8TCneM2YsiXT7mrzT21EacHnPpz5YyUdj64na", networkID: "mainnet", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		a, err := Decode(tt.addr)
+		if err != nil {
+			t.Fatalf("Decode(%q): %v", tt.addr, err)
+		}
+
+		err = a.CheckNetwork(tt.networkID)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckNetwork(%q) of %s: err = %v, want error %v", tt.networkID, tt.addr[:16], err, tt.wantErr)
+		}
+	}
+}
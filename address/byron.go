@@ -0,0 +1,199 @@
+package address
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/big"
+	"strings"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+const mainnetProtocolMagic = 764824073
+
+// Byron address attribute holding the protocol magic of test networks.
+const byronAttrProtocolMagic = 2
+
+// decodeByron decodes a base58 Byron address: a CBOR array of a tag 24
+// payload and its CRC32.
+func decodeByron(addr string) (a Address, err error) {
+	data, err := decodeBase58(addr)
+	if err != nil {
+		return a, err
+	}
+
+	r := cborReader{data: data}
+
+	if n, err := r.head(cborArray); err != nil || n != 2 {
+		return a, fmt.Errorf("not a byron address")
+	}
+
+	if tag, err := r.head(cborTag); err != nil || tag != 24 {
+		return a, fmt.Errorf("not a byron address")
+	}
+
+	payload, err := r.bytes()
+	if err != nil {
+		return a, err
+	}
+
+	crc, err := r.head(cborUint)
+	if err != nil {
+		return a, err
+	}
+
+	if uint64(crc32.ChecksumIEEE(payload)) != crc {
+		return a, fmt.Errorf("invalid checksum")
+	}
+
+	a = Address{
+		Type:          TypeByron,
+		Network:       NetworkMainnet,
+		ProtocolMagic: mainnetProtocolMagic,
+		Bytes:         data,
+	}
+
+	// payload: [address root, attributes, address type]
+	p := cborReader{data: payload}
+
+	if n, err := p.head(cborArray); err != nil || n != 3 {
+		return a, fmt.Errorf("invalid byron payload")
+	}
+
+	if a.Payment, err = p.bytes(); err != nil {
+		return a, err
+	}
+
+	attrs, err := p.head(cborMap)
+	if err != nil {
+		return a, err
+	}
+
+	for i := uint64(0); i < attrs; i++ {
+		key, err := p.head(cborUint)
+		if err != nil {
+			return a, err
+		}
+
+		value, err := p.bytes()
+		if err != nil {
+			return a, err
+		}
+
+		if key != byronAttrProtocolMagic {
+			continue
+		}
+
+		v := cborReader{data: value}
+		if a.ProtocolMagic, err = v.head(cborUint); err != nil {
+			return a, err
+		}
+
+		if a.ProtocolMagic != mainnetProtocolMagic {
+			a.Network = NetworkTestnet
+		}
+	}
+
+	return a, nil
+}
+
+func decodeBase58(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+
+	for _, c := range s {
+		v := strings.IndexRune(base58Alphabet, c)
+		if v < 0 {
+			return nil, fmt.Errorf("invalid character %q", c)
+		}
+
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(v)))
+	}
+
+	// every leading '1' is a leading zero byte
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+// ----------------------------------------------------------------------
+
+// CBOR major types used by Byron addresses.
+const (
+	cborUint  = 0
+	cborBytes = 2
+	cborArray = 4
+	cborMap   = 5
+	cborTag   = 6
+)
+
+// cborReader reads the few definite-length CBOR items Byron addresses are
+// made of.
+type cborReader struct {
+	data []byte
+}
+
+func (r *cborReader) head(major byte) (uint64, error) {
+	if len(r.data) == 0 {
+		return 0, fmt.Errorf("unexpected end of cbor")
+	}
+
+	b := r.data[0]
+	r.data = r.data[1:]
+
+	if b>>5 != major {
+		return 0, fmt.Errorf("unexpected cbor type %d", b>>5)
+	}
+
+	info := b & 0x1f
+	if info < 24 {
+		return uint64(info), nil
+	}
+
+	size := 0
+	switch info {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	default:
+		return 0, fmt.Errorf("unsupported cbor length")
+	}
+
+	if len(r.data) < size {
+		return 0, fmt.Errorf("unexpected end of cbor")
+	}
+
+	var v uint64
+	for _, c := range r.data[:size] {
+		v = v<<8 | uint64(c)
+	}
+
+	r.data = r.data[size:]
+
+	return v, nil
+}
+
+func (r *cborReader) bytes() ([]byte, error) {
+	n, err := r.head(cborBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(r.data)) < n {
+		return nil, fmt.Errorf("unexpected end of cbor")
+	}
+
+	b := r.data[:n]
+	r.data = r.data[n:]
+
+	return b, nil
+}
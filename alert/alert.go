@@ -0,0 +1,143 @@
+// Package alert delivers operator alerts to webhooks.
+//
+// Alerts are deduplicated by key: while a condition persists, its alert is
+// sent once per dedup window. Resolving the key lets the next occurrence
+// through right away.
+package alert
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+)
+
+const (
+	KindLowInventory  = "low_inventory"
+	KindLowBalance    = "low_balance"
+	KindWalletSyncing = "wallet_syncing"
+	KindPayoutFailed  = "payout_failed"
+	KindRollback      = "payout_rollback"
+	KindPayoutManual  = "payout_manual"
+)
+
+// queueSize bounds the alerts waiting for delivery. Alerts beyond it are
+// dropped rather than blocking the poller.
+const queueSize = 100
+
+// Alert is the JSON body POSTed to webhooks.
+type Alert struct {
+	Kind     string `json:"kind"`
+	Key      string `json:"key"`
+	WalletID string `json:"wallet_id,omitempty"`
+	PolicyID string `json:"policy_id,omitempty"`
+	AssetID  string `json:"asset_id,omitempty"`
+	TxID     string `json:"tx_id,omitempty"`
+	Message  string `json:"message"`
+
+	Time time.Time `json:"time"`
+}
+
+type Notifier struct {
+	webhooks []*Webhook
+	dedup    time.Duration
+
+	mx   *sync.Mutex
+	sent map[string]time.Time // last send per alert key
+
+	queue chan delivery
+}
+
+// delivery is a queued alert with the context it was raised in, for logging.
+type delivery struct {
+	ctx   context.Context
+	alert Alert
+}
+
+// NewNotifier returns a notifier of the configured webhooks. Alerts are
+// queued until Deliver runs.
+func NewNotifier(conf config.AlertConfig) *Notifier {
+	n := &Notifier{
+		dedup: time.Duration(conf.DedupSeconds) * time.Second,
+		mx:    &sync.Mutex{},
+		sent:  make(map[string]time.Time),
+		queue: make(chan delivery, queueSize),
+	}
+
+	for _, w := range conf.Webhooks {
+		n.webhooks = append(n.webhooks, NewWebhook(w.URL, w.Secret, int(conf.Retries)))
+	}
+
+	return n
+}
+
+// Notify queues the alert unless one with the same key was sent within the
+// dedup window.
+func (n *Notifier) Notify(ctx context.Context, a Alert) {
+	if len(n.webhooks) == 0 {
+		return
+	}
+
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	n.prune()
+
+	if _, ok := n.sent[a.Key]; ok {
+		return
+	}
+
+	if a.Time.IsZero() {
+		a.Time = time.Now().UTC()
+	}
+
+	select {
+	case n.queue <- delivery{ctx: context.WithoutCancel(ctx), alert: a}:
+		n.sent[a.Key] = time.Now()
+	default:
+		slog.WarnContext(ctx, "Alert queue full, dropping alert", "kind", a.Kind, "key", a.Key)
+	}
+}
+
+// Resolve forgets the alert key, so the condition alerts again as soon as it
+// returns.
+func (n *Notifier) Resolve(key string) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	delete(n.sent, key)
+}
+
+// prune forgets the alert keys whose dedup window has passed. n.mx must be
+// held.
+func (n *Notifier) prune() {
+	for key, sent := range n.sent {
+		if time.Since(sent) >= n.dedup {
+			delete(n.sent, key)
+		}
+	}
+}
+
+// Deliver sends the queued alerts to the webhooks until ctx is cancelled. It
+// is run as a worker, so it runs again should it panic.
+func (n *Notifier) Deliver(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d := <-n.queue:
+			n.send(d)
+		}
+	}
+}
+
+func (n *Notifier) send(d delivery) {
+	for i, w := range n.webhooks {
+		// webhook URLs may carry tokens, so they are logged by index
+		if err := w.Send(d.alert); err != nil {
+			slog.ErrorContext(d.ctx, "Error delivering alert", "kind", d.alert.Kind, "key", d.alert.Key, "webhook", i, "error", err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package alert
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Signature"
+	TimestampHeader = "X-Timestamp"
+)
+
+// Webhook POSTs alerts as JSON. The signature header carries
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)), where the
+// timestamp is the Unix time in the timestamp header, so receivers can reject
+// forged and replayed deliveries.
+type Webhook struct {
+	url     string
+	secret  []byte
+	retries int
+
+	client *http.Client
+}
+
+func NewWebhook(url, secret string, retries int) *Webhook {
+	return &Webhook{
+		url:     url,
+		secret:  []byte(secret),
+		retries: retries,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send delivers the alert, retrying with exponential backoff until the
+// endpoint answers 2xx or the retries run out.
+func (w *Webhook) Send(a Alert) (err error) {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	backoff := time.Second
+
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		if err = w.post(body); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// post delivers the body once. Its errors leave out the URL, which may carry
+// a token.
+func (w *Webhook) post(body []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return withoutURL(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(w.secret, timestamp, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return withoutURL(err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s - %s", resp.Status, string(b))
+	}
+
+	return nil
+}
+
+// withoutURL strips the URL from the errors of net/http and net/url.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+
+	return err
+}
+
+// Sign returns the hex HMAC-SHA256 of a delivery.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
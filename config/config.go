@@ -2,13 +2,15 @@ package config
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/bykovme/goconfig"
-	"github.com/joho/godotenv"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/helpers"
 )
 
 type Config struct {
@@ -16,24 +18,119 @@ type Config struct {
 
 	CardanoWalletURL string `json:"cardano_wallet_url"`
 
+	// Network is the network the addresses in the config are checked
+	// against: mainnet or one of the testnets, see Networks. It has no
+	// default and is required once the config holds addresses.
+	Network string `json:"network"`
+
 	TLS TLSConfig `json:"tls"`
 
+	Admin AdminConfig `json:"admin"`
+
 	Wallets map[string]WalletConfig `json:"wallets"`
+
+	Refund RefundConfig `json:"refund"`
+
+	Price PriceConfig `json:"price"`
+
+	// WalletSelection picks the wallet that sells an asset listed by several
+	// wallets: WalletSelectionPriority, WalletSelectionMostStock or
+	// WalletSelectionRoundRobin.
+	WalletSelection string `json:"wallet_selection"`
+
+	// ReservationTTLSeconds is how long stock checked by CheckTokenBalance
+	// stays reserved for the purchase.
+	ReservationTTLSeconds uint64 `json:"reservation_ttl_seconds"`
+
+	Alerts AlertConfig `json:"alerts"`
+
+	Payout PayoutConfig `json:"payout"`
+
+	Workers map[string]WorkerConfig `json:"workers"`
+
+	// WalletConcurrency is how many wallets are polled at once.
+	WalletConcurrency uint64 `json:"wallet_concurrency"`
+
+	// StateDir holds the state files: wallet IDs, purchases, refunds and the
+	// rest.
+	StateDir string `json:"state_dir"`
+
+	// AddressPoolGap is the address pool gap of wallets restored from a
+	// mnemonic.
+	AddressPoolGap uint64 `json:"address_pool_gap"`
+
+	Secrets SecretsConfig `json:"secrets"`
+
+	WalletPassphrases PassphraseConfig `json:"wallet_passphrases"`
+
+	Log LogConfig `json:"log"`
+
+	// EnvFile and ConfigPath are where the config was loaded from.
+	EnvFile    string `json:"-"`
+	ConfigPath string `json:"-"`
+
+	// flags are reapplied when the config is reloaded.
+	flags Flags
 }
 
+const NetworkMainnet = "mainnet"
+
+// Networks are the known networks; all but mainnet share the testnet
+// address tag.
+var Networks = []string{NetworkMainnet, "testnet", "preprod", "preview"}
+
+const (
+	WalletSelectionPriority   = "priority"
+	WalletSelectionMostStock  = "most_stock"
+	WalletSelectionRoundRobin = "round_robin"
+)
+
 type TLSConfig struct {
 	CertPath string   `json:"cert_path"`
 	IPs      []string `json:"ips"`
 }
 
+// AdminConfig sets up the admin RPCs. They are served on a listener of their
+// own at Address, to callers presenting Token as a bearer token or a client
+// certificate signed by ClientCAFile. CertFile and KeyFile serve the
+// listener over TLS, which client certificates need.
+type AdminConfig struct {
+	Enabled bool   `json:"enabled"`
+	Address string `json:"address"`
+
+	// Token may refer to a secret, "secret:NAME".
+	Token string `json:"token"`
+
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file"`
+}
+
 type WalletConfig struct {
 	Mnemonic string  `json:"mnemonic_sentence"`
 	Assets   []Asset `json:"assets"`
 
+	// Priority orders wallets selling the same asset, lowest first.
+	Priority int `json:"priority"`
+
+	Sweep SweepConfig `json:"sweep"`
+
+	Key        string `json:"-"`
 	ID         string `json:"-"`
 	Passphrase string `json:"-"`
 }
 
+// SweepConfig moves sale proceeds from the wallet to a cold address. When
+// the available lovelace exceeds HighWaterLovelace, everything above
+// LowWaterLovelace is sent to ColdAddress; the rest pays deposits and fees.
+// The balance is checked on every poll, or once per IntervalSeconds when set.
+type SweepConfig struct {
+	ColdAddress       string `json:"cold_address"`
+	HighWaterLovelace uint64 `json:"high_water_lovelace"`
+	LowWaterLovelace  uint64 `json:"low_water_lovelace"`
+	IntervalSeconds   uint64 `json:"interval_seconds"`
+}
+
 type Asset struct {
 	PolicyID                  string  `json:"policy_id"`
 	AssetID                   string  `json:"asset_id"`
@@ -49,60 +146,449 @@ type Asset struct {
 
 	Buffer        uint64 `json:"buffer"`
 	RewardAddress string `json:"reward_address"`
+
+	// OverpaymentPolicy decides what happens to lovelace paid above price,
+	// deposit and processing fee. Surplus up to OverpaymentDust is kept.
+	OverpaymentPolicy string `json:"overpayment_policy"`
+	OverpaymentDust   uint64 `json:"overpayment_dust"`
+	DonationAddress   string `json:"donation_address"`
+
+	Sale SaleRules `json:"sale"`
+
+	// PayoutTTLSeconds is the time to live of payout transactions.
+	PayoutTTLSeconds uint64 `json:"payout_ttl_seconds"`
+
+	// AllowScriptPayout allows paying tokens out to script addresses.
+	AllowScriptPayout bool `json:"allow_script_payout"`
 }
 
-type walletsConfig struct {
-	Wallets map[string]WalletConfig `json:"wallets"`
+// SaleRules limit when and how much of an asset can be sold. Sales run from
+// StartTime up to EndTime and from StartEpoch through EndEpoch; caps count
+// lots of AssetQuantity. Zero values mean no limit.
+type SaleRules struct {
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	StartEpoch uint64    `json:"start_epoch"`
+	EndEpoch   uint64    `json:"end_epoch"`
+
+	TotalCap    uint64 `json:"total_cap"`
+	PerBuyerCap uint64 `json:"per_buyer_cap"`
+	PerTxCap    uint64 `json:"per_tx_cap"`
 }
 
+const (
+	OverpaymentReturn = "return"
+	OverpaymentKeep   = "keep"
+	OverpaymentDonate = "donate"
+)
+
+const defaultOverpaymentDust = 1000000 // 1 ADA
+
+// RefundConfig controls how purchases that can't be fulfilled are paid back.
+type RefundConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Mode is either RefundModeAuto or RefundModeApproval.
+	Mode string `json:"mode"`
+
+	// Fee is the lovelace kept from the received amount to cover the refund.
+	Fee uint64 `json:"fee"`
+}
+
+const (
+	RefundModeAuto     = "auto"
+	RefundModeApproval = "approval"
+)
+
+// PriceConfig selects where asset prices come from and how long a fetched
+// price may be served.
+type PriceConfig struct {
+	Source string `json:"source"`
+
+	// File is the JSON price file of the file source.
+	File string `json:"file"`
+
+	// Oracle source endpoints, see price.OracleSource.
+	OracleTokenURL  string `json:"oracle_token_url"`
+	OracleAdaUSDURL string `json:"oracle_ada_usd_url"`
+	OracleField     string `json:"oracle_field"`
+
+	RefreshSeconds  uint64 `json:"refresh_seconds"`
+	MaxAgeSeconds   uint64 `json:"max_age_seconds"`
+	QuoteTTLSeconds uint64 `json:"quote_ttl_seconds"`
+}
+
+// PayoutConfig sets how submitted payouts are tracked. A payout that expires
+// before reaching the ledger is rebuilt and resubmitted up to MaxResubmits
+// times, then refunded. A payout in the ledger is followed until it is
+// FinalityDepth blocks deep.
+type PayoutConfig struct {
+	MaxResubmits  uint64 `json:"max_resubmits"`
+	FinalityDepth uint64 `json:"finality_depth"`
+
+	// TTLSeconds is the payout TTL of assets that don't set their own, and
+	// the TTL of refunds and sweeps.
+	TTLSeconds uint64 `json:"ttl_seconds"`
+}
+
+// WorkerConfig sets how often a background worker runs: every
+// IntervalSeconds plus a random delay of up to JitterSeconds.
+type WorkerConfig struct {
+	IntervalSeconds uint64 `json:"interval_seconds"`
+	JitterSeconds   uint64 `json:"jitter_seconds"`
+}
+
+// Background workers, the keys of Config.Workers.
+const (
+	WorkerWallets = "wallets"
+	WorkerPayouts = "payouts"
+	WorkerPrices  = "prices"
+)
+
+var defaultWorkers = map[string]WorkerConfig{
+	WorkerWallets: {IntervalSeconds: 5, JitterSeconds: 1},
+	WorkerPayouts: {IntervalSeconds: 30, JitterSeconds: 5},
+	WorkerPrices:  {IntervalSeconds: 2},
+}
+
+// AlertConfig sets when the wallet poller raises alerts and where they are
+// delivered. A zero threshold disables its alert.
+type AlertConfig struct {
+	Webhooks []WebhookConfig `json:"webhooks"`
+
+	// MinLots alerts when an asset has fewer lots for sale than this.
+	MinLots uint64 `json:"min_lots"`
+
+	// MinLovelace alerts when a wallet's available lovelace drops below it.
+	MinLovelace uint64 `json:"min_lovelace"`
+
+	// SyncingSeconds alerts when a wallet hasn't been ready for this long.
+	SyncingSeconds uint64 `json:"syncing_seconds"`
+
+	// PayoutFailures alerts on every purchase that couldn't be paid out.
+	PayoutFailures bool `json:"payout_failures"`
+
+	// Retries is how many times a failed delivery is retried.
+	Retries uint64 `json:"retries"`
+
+	// DedupSeconds is how long an alert isn't sent again while its condition
+	// persists.
+	DedupSeconds uint64 `json:"dedup_seconds"`
+}
+
+// WebhookConfig is an endpoint alerts are POSTed to. The body is signed with
+// HMAC-SHA256 under Secret, a "secret:NAME" reference to the secret store.
+type WebhookConfig struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// LogConfig sets the log output: Format is "json" or "text", Level one of
+// "debug", "info", "warn" and "error".
+type LogConfig struct {
+	Format string `json:"format"`
+	Level  string `json:"level"`
+}
+
+// SecretsConfig selects where wallet mnemonics, passphrases and other
+// secrets are kept. Config values of the form "secret:NAME" are read from
+// the store.
+type SecretsConfig struct {
+	Backend string `json:"backend"`
+
+	// File is the secrets file of the file backends, in the state dir unless
+	// absolute.
+	File string `json:"file"`
+
+	// The key of the encrypted file is read from the KeyEnv environment
+	// variable, or else from KeyFile: 32 bytes, hex or base64 encoded.
+	KeyEnv  string `json:"key_env"`
+	KeyFile string `json:"key_file"`
+
+	// Vault KV version 2 engine. The token is read from the VaultTokenEnv
+	// environment variable.
+	VaultAddress  string `json:"vault_address"`
+	VaultMount    string `json:"vault_mount"`
+	VaultPath     string `json:"vault_path"`
+	VaultTokenEnv string `json:"vault_token_env"`
+}
+
+// PassphraseConfig is how the spending passphrases of restored wallets are
+// made. With a MasterSecret, a "secret:NAME" reference, they are derived
+// from it and the wallet key, so a wallet whose state was lost can be taken
+// over again with the same policy; otherwise they are random.
+type PassphraseConfig struct {
+	helpers.PasswordPolicy
+
+	MasterSecret string `json:"master_secret"`
+}
+
+const (
+	SecretsBackendFile          = "file"
+	SecretsBackendEncryptedFile = "encrypted_file"
+	SecretsBackendVault         = "vault"
+)
+
+// SecretRefPrefix starts a config value that refers to a secret by name.
+const SecretRefPrefix = "secret:"
+
+// IsSecretRef reports whether the config value refers to a secret.
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, SecretRefPrefix)
+}
+
+const (
+	PriceSourceStatic = "static"
+	PriceSourceFile   = "file"
+	PriceSourceOracle = "oracle"
+)
+
 type InternalConfig struct {
 	Wallets map[string]InternalWalletConfig `json:"wallets"`
 }
 
+// InternalWalletConfig is a restored wallet. WalletPassphrase is a
+// reference to the passphrase in the secret store, or the passphrase itself
+// until it is migrated.
 type InternalWalletConfig struct {
 	WalletID         string `json:"wallet_id"`
 	WalletPassphrase string `json:"wallet_passphrase"`
 }
 
-func LoadConfig() (loadedConfig *Config, err error) {
-	// loads values from .env into the system
-	if err := godotenv.Overload("../.env.local"); err != nil {
-		log.Println("No .env file found")
-		log.Println(err)
+// LoadConfig loads and validates the config the server starts with.
+func LoadConfig(flags Flags) (loadedConfig *Config, err error) {
+	loadedConfig, err = Load(flags)
+	if err != nil {
+		return nil, err
 	}
 
-	loadedConfig = &Config{
-		ServerPort:       os.Getenv("SERVER_PORT"),
-		CardanoWalletURL: os.Getenv("CARDANO_WALLET_URL"),
-		TLS: TLSConfig{
-			CertPath: os.Getenv("PATH_TO_CERTS"),
-			IPs:      strings.Split(strings.ReplaceAll(os.Getenv("IP"), " ", ""), ";"),
-		},
+	if err = loadedConfig.Validate(); err != nil {
+		return nil, err
 	}
 
-	var wallets walletsConfig
+	return loadedConfig, nil
+}
 
-	err = goconfig.LoadConfig(os.Getenv("CONFIG_PATH"), &wallets)
+// Load builds the config from its layers: the config file, overridden by the
+// environment, overridden by flags. It fills in the defaults but doesn't
+// validate it.
+func Load(flags Flags) (loadedConfig *Config, err error) {
+	envFile, err := loadEnvFile(flags)
 	if err != nil {
-		fmt.Println("Error: " + err.Error())
-		os.Exit(1)
+		return nil, err
+	}
+
+	configPath := first(flags.ConfigPath, os.Getenv("CONFIG_PATH"), defaultConfigPath)
+
+	loadedConfig = &Config{}
+	if err = goconfig.LoadConfig(configPath, loadedConfig); err != nil {
+		return nil, fmt.Errorf("loading %s: %w", configPath, err)
+	}
+
+	loadedConfig.EnvFile = envFile
+	loadedConfig.ConfigPath = configPath
+	loadedConfig.flags = flags
+
+	if err = loadedConfig.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	loadedConfig.applyFlags(flags)
+	loadedConfig.setDefaults()
+
+	return loadedConfig, nil
+}
+
+// setDefaults fills in the values left unset by every layer.
+func (c *Config) setDefaults() {
+	if c.StateDir == "" {
+		c.StateDir = defaultStateDir
+	}
+
+	if c.AddressPoolGap == 0 {
+		c.AddressPoolGap = 20
+	}
+
+	p := &c.WalletPassphrases.PasswordPolicy
+	if p.Length == 0 && p.Classes == nil && p.MinPerClass == 0 && p.MinEntropyBits == 0 {
+		*p = helpers.DefaultPasswordPolicy()
+	}
+
+	if p.Length == 0 {
+		p.Length = helpers.DefaultPasswordPolicy().Length
+	}
+
+	if p.Classes == nil {
+		p.Classes = helpers.DefaultPasswordPolicy().Classes
 	}
 
-	for i := range wallets.Wallets {
-		for j := range wallets.Wallets[i].Assets {
-			quantity := wallets.Wallets[i].Assets[j].AssetQuantity
-			decimals := float64(wallets.Wallets[i].Assets[j].AssetDecimals)
-			wallets.Wallets[i].Assets[j].AssetQuantityWithDecimals = uint64(quantity * math.Pow(10, decimals))
+	if c.Log.Format == "" {
+		c.Log.Format = "json"
+	}
+
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+	}
+
+	if c.Secrets.Backend == "" {
+		c.Secrets.Backend = SecretsBackendFile
+	}
+
+	if c.Secrets.File == "" {
+		c.Secrets.File = "secrets.json"
+		if c.Secrets.Backend == SecretsBackendEncryptedFile {
+			c.Secrets.File = "secrets.enc"
+		}
+	}
+
+	if c.Secrets.KeyEnv == "" {
+		c.Secrets.KeyEnv = "SECRETS_KEY"
+	}
+
+	if c.Secrets.VaultMount == "" {
+		c.Secrets.VaultMount = "secret"
+	}
+
+	if c.Secrets.VaultPath == "" {
+		c.Secrets.VaultPath = "cardano-wallet-backend"
+	}
+
+	if c.Secrets.VaultTokenEnv == "" {
+		c.Secrets.VaultTokenEnv = "VAULT_TOKEN"
+	}
+
+	if c.Payout.TTLSeconds == 0 {
+		c.Payout.TTLSeconds = 3600
+	}
+
+	if c.Admin.Address == "" {
+		c.Admin.Address = "127.0.0.1:9091"
+	}
+
+	for i := range c.Wallets {
+		w := c.Wallets[i]
+		w.Key = i
+		c.Wallets[i] = w
+
+		for j := range c.Wallets[i].Assets {
+			quantity := c.Wallets[i].Assets[j].AssetQuantity
+			decimals := float64(c.Wallets[i].Assets[j].AssetDecimals)
+			c.Wallets[i].Assets[j].AssetQuantityWithDecimals = uint64(quantity * math.Pow(10, decimals))
 
-			if wallets.Wallets[i].Assets[j].Buffer == 0 {
-				wallets.Wallets[i].Assets[j].Buffer = uint64(quantity * math.Pow(10, decimals))
+			if c.Wallets[i].Assets[j].Buffer == 0 {
+				c.Wallets[i].Assets[j].Buffer = uint64(quantity * math.Pow(10, decimals))
+			}
+
+			if c.Wallets[i].Assets[j].OverpaymentPolicy == "" {
+				c.Wallets[i].Assets[j].OverpaymentPolicy = OverpaymentReturn
+			}
+
+			if c.Wallets[i].Assets[j].OverpaymentDust == 0 {
+				c.Wallets[i].Assets[j].OverpaymentDust = defaultOverpaymentDust
+			}
+
+			if c.Wallets[i].Assets[j].PayoutTTLSeconds == 0 {
+				c.Wallets[i].Assets[j].PayoutTTLSeconds = c.Payout.TTLSeconds
 			}
 		}
 	}
 
-	loadedConfig.Wallets = wallets.Wallets
+	if c.Refund.Mode == "" {
+		c.Refund.Mode = RefundModeApproval
+	}
 
-	log.Println("Loaded config:", loadedConfig)
+	if c.Price.Source == "" {
+		c.Price.Source = PriceSourceStatic
+	}
 
-	return loadedConfig, nil
+	if c.Price.RefreshSeconds == 0 {
+		c.Price.RefreshSeconds = 60
+	}
+
+	if c.Price.MaxAgeSeconds == 0 {
+		c.Price.MaxAgeSeconds = 600
+	}
+
+	if c.Price.QuoteTTLSeconds == 0 {
+		c.Price.QuoteTTLSeconds = 900
+	}
+
+	if c.WalletSelection == "" {
+		c.WalletSelection = WalletSelectionPriority
+	}
+
+	if c.ReservationTTLSeconds == 0 {
+		c.ReservationTTLSeconds = 600
+	}
+
+	if c.Payout.MaxResubmits == 0 {
+		c.Payout.MaxResubmits = 3
+	}
+
+	if c.Workers == nil {
+		c.Workers = make(map[string]WorkerConfig)
+	}
+
+	for name, worker := range defaultWorkers {
+		if c.Workers[name].IntervalSeconds == 0 {
+			c.Workers[name] = worker
+		}
+	}
+
+	if c.WalletConcurrency == 0 {
+		c.WalletConcurrency = 4
+	}
+
+	if c.Payout.FinalityDepth == 0 {
+		c.Payout.FinalityDepth = 15
+	}
+
+	if c.Alerts.Retries == 0 {
+		c.Alerts.Retries = 5
+	}
+
+	if c.Alerts.DedupSeconds == 0 {
+		c.Alerts.DedupSeconds = 3600
+	}
+}
+
+// LogValue logs the config without its secrets.
+func (c *Config) LogValue() slog.Value {
+	wallets := make([]slog.Attr, 0, len(c.Wallets))
+	for key, w := range c.Wallets {
+		wallets = append(wallets, slog.Any(key, w))
+	}
+
+	return slog.GroupValue(
+		slog.String("config_path", c.ConfigPath),
+		slog.String("env_file", c.EnvFile),
+		slog.String("state_dir", c.StateDir),
+		slog.String("server_port", c.ServerPort),
+		slog.String("cardano_wallet_url", c.CardanoWalletURL),
+		slog.String("secrets_backend", c.Secrets.Backend),
+		slog.String("price_source", c.Price.Source),
+		slog.String("wallet_selection", c.WalletSelection),
+		slog.Attr{Key: "wallets", Value: slog.GroupValue(wallets...)},
+	)
+}
+
+// LogValue logs the wallet with its mnemonic and passphrase redacted.
+func (w WalletConfig) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", w.ID),
+		slog.Int("priority", w.Priority),
+		slog.Int("assets", len(w.Assets)),
+		slog.String("mnemonic", redactedValue(w.Mnemonic)),
+		slog.String("passphrase", redactedValue(w.Passphrase)),
+	)
+}
+
+// redactedValue hides a secret, but shows a reference to one and whether it
+// is set.
+func redactedValue(value string) string {
+	if value == "" || IsSecretRef(value) {
+		return value
+	}
+
+	return redacted
 }
@@ -0,0 +1,232 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	defaultEnvFile    = "../.env.local"
+	defaultConfigPath = "config.json"
+	defaultStateDir   = "/data"
+)
+
+// Flags are the settings given on the command line. Set flags take
+// precedence over the environment, which takes precedence over the config
+// file.
+type Flags struct {
+	EnvFile    string
+	ConfigPath string
+	StateDir   string
+	CertDir    string
+
+	ServerPort       string
+	CardanoWalletURL string
+	Network          string
+
+	LogFormat string
+	LogLevel  string
+
+	PollIntervalSeconds   uint64
+	AddressPoolGap        uint64
+	ReservationTTLSeconds uint64
+	PayoutTTLSeconds      uint64
+}
+
+// ParseFlags parses the flags of the command name.
+func ParseFlags(name string, args []string) (flags Flags, err error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.StringVar(&flags.EnvFile, "env-file", "", "env file to load (env ENV_FILE, default "+defaultEnvFile+")")
+	fs.StringVar(&flags.ConfigPath, "config", "", "config file (env CONFIG_PATH, default "+defaultConfigPath+")")
+	fs.StringVar(&flags.StateDir, "state-dir", "", "state directory (env STATE_DIR, default "+defaultStateDir+")")
+	fs.StringVar(&flags.CertDir, "cert-dir", "", "TLS certificate directory (env PATH_TO_CERTS)")
+
+	fs.StringVar(&flags.ServerPort, "port", "", "gRPC port (env SERVER_PORT)")
+	fs.StringVar(&flags.CardanoWalletURL, "cardano-wallet-url", "", "cardano-wallet URL (env CARDANO_WALLET_URL)")
+	fs.StringVar(&flags.Network, "network", "", "network of the configured addresses, mainnet, testnet, preprod or preview (env NETWORK)")
+
+	fs.StringVar(&flags.LogFormat, "log-format", "", "log format, json or text (env LOG_FORMAT)")
+	fs.StringVar(&flags.LogLevel, "log-level", "", "log level: debug, info, warn or error (env LOG_LEVEL)")
+
+	fs.Uint64Var(&flags.PollIntervalSeconds, "poll-interval", 0, "seconds between wallet polls (env POLL_INTERVAL_SECONDS)")
+	fs.Uint64Var(&flags.AddressPoolGap, "address-pool-gap", 0, "address pool gap of restored wallets (env ADDRESS_POOL_GAP)")
+	fs.Uint64Var(&flags.ReservationTTLSeconds, "reservation-ttl", 0, "seconds stock stays reserved (env RESERVATION_TTL_SECONDS)")
+	fs.Uint64Var(&flags.PayoutTTLSeconds, "payout-ttl", 0, "default payout TTL in seconds (env PAYOUT_TTL_SECONDS)")
+
+	if err = fs.Parse(args); err != nil {
+		return flags, err
+	}
+
+	if fs.NArg() > 0 {
+		return flags, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	return flags, nil
+}
+
+// loadEnvFile loads the env file into the environment. Only a missing env
+// file that was asked for is an error.
+func loadEnvFile(flags Flags) (string, error) {
+	envFile := first(flags.EnvFile, os.Getenv("ENV_FILE"))
+	if envFile == "" {
+		if err := godotenv.Overload(defaultEnvFile); err != nil {
+			slog.Info("No .env file found", "path", defaultEnvFile)
+			return "", nil
+		}
+
+		return defaultEnvFile, nil
+	}
+
+	if err := godotenv.Overload(envFile); err != nil {
+		return "", fmt.Errorf("loading %s: %w", envFile, err)
+	}
+
+	return envFile, nil
+}
+
+// applyEnv overrides the config with the environment variables that are set.
+func (c *Config) applyEnv() error {
+	setString(&c.ServerPort, os.Getenv("SERVER_PORT"))
+	setString(&c.CardanoWalletURL, os.Getenv("CARDANO_WALLET_URL"))
+	setString(&c.Network, os.Getenv("NETWORK"))
+	setString(&c.TLS.CertPath, os.Getenv("PATH_TO_CERTS"))
+	setString(&c.StateDir, os.Getenv("STATE_DIR"))
+	setString(&c.Log.Format, os.Getenv("LOG_FORMAT"))
+	setString(&c.Log.Level, os.Getenv("LOG_LEVEL"))
+
+	if ips := strings.ReplaceAll(os.Getenv("IP"), " ", ""); ips != "" {
+		c.TLS.IPs = strings.Split(ips, ";")
+	}
+
+	var pollInterval uint64
+
+	var errs []string
+	for _, v := range []struct {
+		name  string
+		value *uint64
+	}{
+		{"POLL_INTERVAL_SECONDS", &pollInterval},
+		{"ADDRESS_POOL_GAP", &c.AddressPoolGap},
+		{"RESERVATION_TTL_SECONDS", &c.ReservationTTLSeconds},
+		{"PAYOUT_TTL_SECONDS", &c.Payout.TTLSeconds},
+	} {
+		name, value := v.name, v.value
+
+		env := os.Getenv(name)
+		if env == "" {
+			continue
+		}
+
+		n, err := strconv.ParseUint(env, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("env.%s: must be a whole number, got %q", name, env))
+			continue
+		}
+
+		*value = n
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+
+	c.setPollInterval(pollInterval)
+
+	return nil
+}
+
+// applyFlags overrides the config with the flags that are set.
+func (c *Config) applyFlags(flags Flags) {
+	setString(&c.ServerPort, flags.ServerPort)
+	setString(&c.CardanoWalletURL, flags.CardanoWalletURL)
+	setString(&c.Network, flags.Network)
+	setString(&c.TLS.CertPath, flags.CertDir)
+	setString(&c.StateDir, flags.StateDir)
+	setString(&c.Log.Format, flags.LogFormat)
+	setString(&c.Log.Level, flags.LogLevel)
+
+	setUint(&c.AddressPoolGap, flags.AddressPoolGap)
+	setUint(&c.ReservationTTLSeconds, flags.ReservationTTLSeconds)
+	setUint(&c.Payout.TTLSeconds, flags.PayoutTTLSeconds)
+
+	c.setPollInterval(flags.PollIntervalSeconds)
+}
+
+// setPollInterval sets the interval of the wallet poller, when set.
+func (c *Config) setPollInterval(seconds uint64) {
+	if seconds == 0 {
+		return
+	}
+
+	if c.Workers == nil {
+		c.Workers = make(map[string]WorkerConfig)
+	}
+
+	w, ok := c.Workers[WorkerWallets]
+	if !ok {
+		w = defaultWorkers[WorkerWallets]
+	}
+
+	w.IntervalSeconds = seconds
+	c.Workers[WorkerWallets] = w
+}
+
+func setString(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+func setUint(field *uint64, value uint64) {
+	if value != 0 {
+		*field = value
+	}
+}
+
+// first returns the first value that is set.
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// ----------------------------------------------------------------------
+
+const redacted = "[redacted]"
+
+// Redacted returns a copy of the config with its secrets replaced, for
+// printing and logging. References to secrets are kept.
+func (c *Config) Redacted() *Config {
+	r := *c
+
+	r.Wallets = make(map[string]WalletConfig, len(c.Wallets))
+	for key, w := range c.Wallets {
+		w.Mnemonic = redactedValue(w.Mnemonic)
+		w.Passphrase = redactedValue(w.Passphrase)
+
+		r.Wallets[key] = w
+	}
+
+	r.Alerts.Webhooks = make([]WebhookConfig, len(c.Alerts.Webhooks))
+	for i, w := range c.Alerts.Webhooks {
+		w.Secret = redactedValue(w.Secret)
+		r.Alerts.Webhooks[i] = w
+	}
+
+	r.WalletPassphrases.MasterSecret = redactedValue(c.WalletPassphrases.MasterSecret)
+	r.Admin.Token = redactedValue(c.Admin.Token)
+
+	return &r
+}
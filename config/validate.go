@@ -0,0 +1,423 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/address"
+)
+
+// Protocol minimums the asset fees are checked against. MinFeeLovelace is the
+// constant part of the network fee and MinDepositLovelace the least ADA an
+// output carrying a native token needs.
+const (
+	MinFeeLovelace     = 155381
+	MinDepositLovelace = 1000000
+)
+
+const (
+	policyIDLength   = 56
+	maxAssetIDLength = 64
+	maxDecimals      = 19
+
+	// the address pool gaps cardano-wallet accepts
+	minAddressPoolGap = 10
+	maxAddressPoolGap = 100000
+)
+
+// ValidationError is a problem with the config value at Path, a JSON path
+// into the config file, or env.NAME for an environment variable.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors are all the problems found in a config.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d config errors:\n%s", len(e), strings.Join(lines, "\n"))
+}
+
+type validator struct {
+	errs ValidationErrors
+
+	// network is the network ID addresses must be on, and addresses is set
+	// once any were found.
+	network   string
+	addresses bool
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the whole config and returns ValidationErrors with every
+// problem found, or nil.
+func (c *Config) Validate() error {
+	v := &validator{network: c.Network}
+
+	if c.Network != "" && !slices.Contains(Networks, c.Network) {
+		v.fail("network", "must be one of %s, got %q", strings.Join(Networks, ", "), c.Network)
+		v.network = ""
+	}
+
+	if port, err := strconv.Atoi(c.ServerPort); err != nil || port < 1 || port > 65535 {
+		v.fail("env.SERVER_PORT", "must be a port number, got %q", c.ServerPort)
+	}
+
+	if u, err := url.Parse(c.CardanoWalletURL); err != nil || u.Scheme == "" || u.Host == "" {
+		v.fail("env.CARDANO_WALLET_URL", "must be an absolute URL, got %q", c.CardanoWalletURL)
+	}
+
+	if c.StateDir == "" {
+		v.fail("state_dir", "is required")
+	}
+
+	if c.AddressPoolGap < minAddressPoolGap || c.AddressPoolGap > maxAddressPoolGap {
+		v.fail("address_pool_gap", "must be between %d and %d, got %d", minAddressPoolGap, maxAddressPoolGap, c.AddressPoolGap)
+	}
+
+	if len(c.Wallets) == 0 {
+		v.fail("wallets", "no wallets configured")
+	}
+
+	keys := make([]string, 0, len(c.Wallets))
+	for key := range c.Wallets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.wallet("wallets."+key, c.Wallets[key])
+	}
+
+	v.listings(keys, c.Wallets)
+
+	v.admin("admin", c.Admin)
+
+	if c.Network == "" && v.addresses {
+		v.fail("network", "is required to check the configured addresses, set it to one of %s", strings.Join(Networks, ", "))
+	}
+
+	switch c.Price.Source {
+	case PriceSourceStatic:
+	case PriceSourceFile:
+		if c.Price.File == "" {
+			v.fail("price.file", "is required by the file source")
+		}
+	case PriceSourceOracle:
+		v.url("price.oracle_token_url", c.Price.OracleTokenURL)
+		v.url("price.oracle_ada_usd_url", c.Price.OracleAdaUSDURL)
+	default:
+		v.fail("price.source", "unknown price source %q", c.Price.Source)
+	}
+
+	switch c.WalletSelection {
+	case WalletSelectionPriority, WalletSelectionMostStock, WalletSelectionRoundRobin:
+	default:
+		v.fail("wallet_selection", "unknown wallet selection %q", c.WalletSelection)
+	}
+
+	switch c.Refund.Mode {
+	case RefundModeAuto, RefundModeApproval:
+	default:
+		v.fail("refund.mode", "unknown refund mode %q", c.Refund.Mode)
+	}
+
+	if err := c.WalletPassphrases.Validate(); err != nil {
+		v.fail("wallet_passphrases", "%s", err)
+	}
+
+	if c.WalletPassphrases.MasterSecret != "" && !IsSecretRef(c.WalletPassphrases.MasterSecret) {
+		v.fail("wallet_passphrases.master_secret", "must be a %sNAME reference to the secret store", SecretRefPrefix)
+	}
+
+	switch c.Log.Format {
+	case "json", "text":
+	default:
+		v.fail("log.format", "unknown log format %q", c.Log.Format)
+	}
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		v.fail("log.level", "unknown log level %q", c.Log.Level)
+	}
+
+	switch c.Secrets.Backend {
+	case SecretsBackendFile:
+	case SecretsBackendEncryptedFile:
+		if os.Getenv(c.Secrets.KeyEnv) == "" && c.Secrets.KeyFile == "" {
+			v.fail("secrets.key_file", "is required when %s is not set", c.Secrets.KeyEnv)
+		}
+	case SecretsBackendVault:
+		v.url("secrets.vault_address", c.Secrets.VaultAddress)
+	default:
+		v.fail("secrets.backend", "unknown secrets backend %q", c.Secrets.Backend)
+	}
+
+	for i, w := range c.Alerts.Webhooks {
+		path := fmt.Sprintf("alerts.webhooks[%d]", i)
+
+		v.url(path+".url", w.URL)
+
+		if w.Secret != "" && !IsSecretRef(w.Secret) {
+			v.fail(path+".secret", "must be a %sNAME reference to the secret store", SecretRefPrefix)
+		}
+	}
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	return v.errs
+}
+
+// admin checks that an enabled admin listener authenticates its callers.
+func (v *validator) admin(path string, a AdminConfig) {
+	if !a.Enabled {
+		return
+	}
+
+	if _, _, err := net.SplitHostPort(a.Address); err != nil {
+		v.fail(path+".address", "must be host:port, got %q", a.Address)
+	}
+
+	if a.Token == "" && a.ClientCAFile == "" {
+		v.fail(path, "admin is enabled without a token or client_ca_file")
+	}
+
+	if (a.CertFile == "") != (a.KeyFile == "") {
+		v.fail(path, "cert_file and key_file go together")
+	}
+
+	if a.ClientCAFile != "" && a.CertFile == "" {
+		v.fail(path+".client_ca_file", "client certificates need cert_file and key_file")
+	}
+}
+
+func (v *validator) wallet(path string, w WalletConfig) {
+	if w.Sweep.ColdAddress != "" {
+		v.address(path+".sweep.cold_address", w.Sweep.ColdAddress)
+
+		if w.Sweep.LowWaterLovelace > w.Sweep.HighWaterLovelace {
+			v.fail(path+".sweep.low_water_lovelace", "is above high_water_lovelace")
+		}
+	}
+
+	seen := make(map[string]bool)
+
+	for i, a := range w.Assets {
+		assetPath := fmt.Sprintf("%s.assets[%d]", path, i)
+
+		v.asset(assetPath, a)
+
+		tokenID := a.PolicyID + "." + a.AssetID
+		if seen[tokenID] {
+			v.fail(assetPath, "%s is listed twice in the wallet", tokenID)
+		}
+		seen[tokenID] = true
+	}
+}
+
+func (v *validator) asset(path string, a Asset) {
+	if len(a.PolicyID) != policyIDLength || !isHex(a.PolicyID) {
+		v.fail(path+".policy_id", "must be %d hex characters, got %q", policyIDLength, a.PolicyID)
+	}
+
+	if len(a.AssetID) > maxAssetIDLength || len(a.AssetID)%2 != 0 || !isHex(a.AssetID) {
+		v.fail(path+".asset_id", "must be a hex asset name of at most %d characters, got %q", maxAssetIDLength, a.AssetID)
+	}
+
+	if a.AssetDecimals > maxDecimals {
+		v.fail(path+".asset_decimals", "must be at most %d, got %d", maxDecimals, a.AssetDecimals)
+	}
+
+	if math.IsNaN(a.AssetQuantity) || a.AssetQuantity <= 0 {
+		v.fail(path+".asset_quantity", "must be positive, got %v", a.AssetQuantity)
+	} else if a.AssetDecimals <= maxDecimals {
+		units := a.AssetQuantity * math.Pow(10, float64(a.AssetDecimals))
+		if units > math.MaxUint64 {
+			v.fail(path+".asset_quantity", "is too large")
+		} else if math.Abs(units-math.Round(units)) > 1e-6 {
+			v.fail(path+".asset_quantity", "has more decimals than asset_decimals")
+		}
+	}
+
+	if a.PriceLovelace == 0 {
+		v.fail(path+".lovelace_quantity", "must be positive")
+	}
+
+	if a.Fee < MinFeeLovelace {
+		v.fail(path+".fee", "must be at least the protocol minimum of %d lovelace, got %d", MinFeeLovelace, a.Fee)
+	}
+
+	if a.Deposit < MinDepositLovelace {
+		v.fail(path+".deposit", "must be at least the protocol minimum of %d lovelace, got %d", MinDepositLovelace, a.Deposit)
+	}
+
+	if a.RewardAddress != "" {
+		v.rewardAddress(path+".reward_address", a.RewardAddress)
+	}
+
+	switch a.OverpaymentPolicy {
+	case OverpaymentReturn, OverpaymentKeep:
+	case OverpaymentDonate:
+		if a.DonationAddress == "" {
+			v.fail(path+".donation_address", "is required by the donate policy")
+		}
+	default:
+		v.fail(path+".overpayment_policy", "unknown overpayment policy %q", a.OverpaymentPolicy)
+	}
+
+	if a.DonationAddress != "" {
+		v.address(path+".donation_address", a.DonationAddress)
+	}
+
+	sale := a.Sale
+
+	if !sale.StartTime.IsZero() && !sale.EndTime.IsZero() && !sale.EndTime.After(sale.StartTime) {
+		v.fail(path+".sale.end_time", "is not after start_time")
+	}
+
+	if sale.EndEpoch > 0 && sale.EndEpoch < sale.StartEpoch {
+		v.fail(path+".sale.end_epoch", "is before start_epoch")
+	}
+}
+
+// listings checks assets listed by several wallets. They may be, to sell
+// from several wallets, but on the same terms, or the price a buyer pays
+// would depend on the wallet selected.
+func (v *validator) listings(keys []string, wallets map[string]WalletConfig) {
+	first := make(map[string]string)
+	firstKey := make(map[string]string)
+	terms := make(map[string]Asset)
+
+	for _, key := range keys {
+		for i, a := range wallets[key].Assets {
+			tokenID := a.PolicyID + "." + a.AssetID
+			path := fmt.Sprintf("wallets.%s.assets[%d]", key, i)
+
+			listed, ok := terms[tokenID]
+			if !ok {
+				first[tokenID] = path
+				firstKey[tokenID] = key
+				terms[tokenID] = a
+				continue
+			}
+
+			// listed twice by one wallet is reported by wallet
+			if firstKey[tokenID] != key && !sameTerms(listed, a) {
+				v.fail(path, "%s is also listed by %s on different terms", tokenID, first[tokenID])
+			}
+		}
+	}
+}
+
+func sameTerms(a, b Asset) bool {
+	return a.PriceLovelace == b.PriceLovelace &&
+		a.AssetQuantity == b.AssetQuantity &&
+		a.AssetDecimals == b.AssetDecimals &&
+		a.Fee == b.Fee &&
+		a.Deposit == b.Deposit &&
+		a.ProcessingFee == b.ProcessingFee &&
+		reflect.DeepEqual(a.Sale, b.Sale)
+}
+
+func (v *validator) address(path, addr string) {
+	v.decodeAddress(path, addr)
+}
+
+// rewardAddress checks that addr is a stake address on the network.
+func (v *validator) rewardAddress(path, addr string) {
+	a, ok := v.decodeAddress(path, addr)
+	if ok && a.Kind() != address.KindReward {
+		v.fail(path, "must be a stake address, got a %s address", a.Kind())
+	}
+}
+
+// decodeAddress decodes addr and checks that it is on the network.
+func (v *validator) decodeAddress(path, addr string) (a address.Address, ok bool) {
+	a, err := address.Decode(addr)
+	if err != nil {
+		v.fail(path, "%s", err)
+		return a, false
+	}
+
+	v.addresses = true
+
+	if v.network == "" {
+		return a, true
+	}
+
+	if err = a.CheckNetwork(v.network); err != nil {
+		v.fail(path, "%s", err)
+		return a, false
+	}
+
+	return a, true
+}
+
+func (v *validator) url(path, value string) {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		v.fail(path, "must be an absolute URL, got %q", value)
+	}
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RestartRequired returns the sections of next that differ from c but are
+// only read when the server starts.
+func (c *Config) RestartRequired(next *Config) (sections []string) {
+	changed := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			sections = append(sections, name)
+		}
+	}
+
+	changed("server_port", c.ServerPort, next.ServerPort)
+	changed("cardano_wallet_url", c.CardanoWalletURL, next.CardanoWalletURL)
+	changed("tls", c.TLS, next.TLS)
+	changed("admin", c.Admin, next.Admin)
+	changed("refund", c.Refund, next.Refund)
+	changed("price", c.Price, next.Price)
+	changed("wallet_selection", c.WalletSelection, next.WalletSelection)
+	changed("reservation_ttl_seconds", c.ReservationTTLSeconds, next.ReservationTTLSeconds)
+	changed("alerts", c.Alerts, next.Alerts)
+	changed("payout", c.Payout, next.Payout)
+	changed("workers", c.Workers, next.Workers)
+	changed("wallet_concurrency", c.WalletConcurrency, next.WalletConcurrency)
+	changed("state_dir", c.StateDir, next.StateDir)
+	changed("address_pool_gap", c.AddressPoolGap, next.AddressPoolGap)
+	changed("secrets", c.Secrets, next.Secrets)
+	changed("wallet_passphrases", c.WalletPassphrases, next.WalletPassphrases)
+
+	return sections
+}
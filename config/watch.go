@@ -0,0 +1,69 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// watchInterval is how often the config file is checked for changes.
+const watchInterval = 2 * time.Second
+
+// Watch reloads the config when its file changes or the process gets SIGHUP,
+// until ctx is cancelled. A config that loads and validates is passed to
+// apply; one that doesn't is logged and the running config is kept. Changes
+// to sections only read at start are logged against the started config.
+func Watch(ctx context.Context, started *Config, apply func(*Config)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	last, _ := os.Stat(started.ConfigPath)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("SIGHUP, reloading config")
+		case <-ticker.C:
+			info, err := os.Stat(started.ConfigPath)
+			if err != nil || !fileChanged(last, info) {
+				continue
+			}
+
+			last = info
+			slog.Info("Config file changed, reloading config", "path", started.ConfigPath)
+		}
+
+		next, err := Load(started.flags)
+		if err == nil {
+			err = next.Validate()
+		}
+
+		if err != nil {
+			slog.Error("Rejected config, keeping the running one", "error", err)
+			continue
+		}
+
+		if sections := started.RestartRequired(next); len(sections) > 0 {
+			slog.Warn("Config changes that apply after a restart", "sections", sections)
+		}
+
+		apply(next)
+	}
+}
+
+func fileChanged(last, info os.FileInfo) bool {
+	if last == nil {
+		return true
+	}
+
+	return !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size()
+}
@@ -2,50 +2,113 @@ package cwalletapi
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/bykovme/goconfig"
 	"github.com/intellisoftalpin/cardano-wallet-backend/config"
 	"github.com/intellisoftalpin/cardano-wallet-backend/helpers"
+	"github.com/intellisoftalpin/cardano-wallet-backend/secret"
 )
 
+// ErrTxNotFound is returned when the wallet doesn't know a transaction.
+var ErrTxNotFound = errors.New("tx not found")
+
+// ErrWalletExists is returned by CreateWallet for a wallet cardano-wallet
+// already has, wrapped in a WalletExistsError.
+var ErrWalletExists = errors.New("wallet already exists")
+
+// WalletExistsError is the conflict cardano-wallet answers when asked to
+// restore a wallet it already has. ID is the ID of that wallet, derived from
+// the mnemonic, or empty when the answer didn't tell it.
+type WalletExistsError struct {
+	ID      string
+	Message string
+}
+
+func (e *WalletExistsError) Error() string {
+	return ErrWalletExists.Error() + ": " + e.Message
+}
+
+func (e *WalletExistsError) Unwrap() error {
+	return ErrWalletExists
+}
+
+// walletIDPattern matches a wallet ID, the hex of a 20 byte hash.
+var walletIDPattern = regexp.MustCompile(`\b[0-9a-f]{40}\b`)
+
+// walletExists reads the conflict answer of cardano-wallet, whose message
+// reads "This operation would yield a wallet with the following id: ID
+// however, I already know of a wallet with this id."
+func walletExists(body []byte) *WalletExistsError {
+	e := &WalletExistsError{Message: string(body)}
+
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+		e.ID = walletIDPattern.FindString(apiErr.Message)
+	}
+
+	return e
+}
+
 type CardanoWalletApi struct {
 	url string
 
+	// internalConfig is the file the IDs of restored wallets and references
+	// to their passphrases in secrets are kept in, and addressPoolGap the gap
+	// they are restored with.
+	internalConfig string
+	addressPoolGap uint64
+	restoreMx      *sync.Mutex
+	secrets        secret.Store
+	passphrases    config.PassphraseConfig
+
 	wallets map[string]config.WalletConfig
 }
 
-func NewCardanoWalletApi(config *config.Config) (*CardanoWalletApi, error) {
-	wallets, err := GetWalletsPasswords(config.CardanoWalletURL, config.Wallets)
+func NewCardanoWalletApi(config *config.Config, secrets secret.Store) (*CardanoWalletApi, error) {
+	c := &CardanoWalletApi{
+		url:            config.CardanoWalletURL,
+		internalConfig: filepath.Join(config.StateDir, internalConfigFile),
+		addressPoolGap: config.AddressPoolGap,
+		restoreMx:      &sync.Mutex{},
+		secrets:        secrets,
+		passphrases:    config.WalletPassphrases,
+	}
+
+	wallets, err := c.GetWalletsPasswords(context.Background(), config.Wallets)
 	if err != nil {
 		return nil, err
 	}
 
 	config.Wallets = wallets
-
-	c := &CardanoWalletApi{
-		url:     config.CardanoWalletURL,
-		wallets: wallets,
-	}
+	c.wallets = wallets
 
 	return c, nil
 }
 
 // Send POST request to cwallet-api
 // Return decoded tx
-func (c *CardanoWalletApi) DecodeTransaction(walletID, txCBOR string) (tx Transaction, err error) {
+func (c *CardanoWalletApi) DecodeTransaction(ctx context.Context, walletID, txCBOR string) (tx Transaction, err error) {
 	body, err := json.Marshal(decodeTxRequest{Transaction: txCBOR})
 	if err != nil {
 		return tx, err
 	}
 
-	resp, err := http.Post(c.url+"/v2/wallets/"+walletID+"/transactions-decode", "application/json", bytes.NewBuffer(body))
+	resp, err := post(ctx, c.url+"/v2/wallets/"+walletID+"/transactions-decode", "application/json", bytes.NewBuffer(body))
 	if err != nil {
 		return tx, err
 	}
@@ -68,13 +131,13 @@ func (c *CardanoWalletApi) DecodeTransaction(walletID, txCBOR string) (tx Transa
 }
 
 // Submit External Transaction
-func (c *CardanoWalletApi) SubmitExternalTransaction(txCBOR string) (string, error) {
+func (c *CardanoWalletApi) SubmitExternalTransaction(ctx context.Context, txCBOR string) (string, error) {
 	b, err := hex.DecodeString(txCBOR)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := http.Post(c.url+"/v2/proxy/transactions", "application/octet-stream", bytes.NewReader(b))
+	resp, err := post(ctx, c.url+"/v2/proxy/transactions", "application/octet-stream", bytes.NewReader(b))
 	if err != nil {
 		return "", err
 	}
@@ -98,8 +161,8 @@ func (c *CardanoWalletApi) SubmitExternalTransaction(txCBOR string) (string, err
 }
 
 // Get transaction by id
-func (c *CardanoWalletApi) GetTransaction(walletID, txID string) ([]byte, error) {
-	resp, err := http.Get(c.url + "/v2/wallets/" + walletID + "/transactions/" + txID)
+func (c *CardanoWalletApi) GetTransaction(ctx context.Context, walletID, txID string) ([]byte, error) {
+	resp, err := get(ctx, c.url+"/v2/wallets/"+walletID+"/transactions/"+txID)
 	if err != nil {
 		return nil, err
 	}
@@ -110,6 +173,10 @@ func (c *CardanoWalletApi) GetTransaction(walletID, txID string) ([]byte, error)
 		return nil, err
 	}
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrTxNotFound, string(b))
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("tx not found: %s - %s", resp.Status, string(b))
 	}
@@ -117,24 +184,47 @@ func (c *CardanoWalletApi) GetTransaction(walletID, txID string) ([]byte, error)
 	return b, nil
 }
 
+// List the wallet's transactions, newest first
+func (c *CardanoWalletApi) ListTransactions(ctx context.Context, walletID string) (txs []Transaction, err error) {
+	resp, err := get(ctx, c.url+"/v2/wallets/"+walletID+"/transactions?order=descending")
+	if err != nil {
+		return txs, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return txs, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return txs, fmt.Errorf("transactions not listed: %s - %s", resp.Status, string(b))
+	}
+
+	if err = json.Unmarshal(b, &txs); err != nil {
+		return txs, err
+	}
+
+	return txs, nil
+}
+
 // Create transaction
-func (c *CardanoWalletApi) CreateTransaction(walletID string, req CreateTransactionRequest) (rawTx []byte, tx Transaction, err error) {
+func (c *CardanoWalletApi) CreateTransaction(ctx context.Context, walletID string, req CreateTransactionRequest) (rawTx []byte, tx Transaction, err error) {
 	body, err := json.Marshal(req)
 	if err != nil {
-		log.Println(err)
 		return rawTx, tx, err
 	}
 
-	resp, err := http.Post(c.url+"/v2/wallets/"+walletID+"/transactions", "application/json", bytes.NewBuffer(body))
+	slog.DebugContext(ctx, "Creating transaction", "wallet_id", walletID, "request", req)
+
+	resp, err := post(ctx, c.url+"/v2/wallets/"+walletID+"/transactions", "application/json", bytes.NewBuffer(body))
 	if err != nil {
-		log.Println(err)
 		return rawTx, tx, err
 	}
 	defer resp.Body.Close()
 
 	rawTx, err = io.ReadAll(resp.Body)
 	if err != nil {
-		log.Println(err)
 		return rawTx, tx, err
 	}
 
@@ -149,9 +239,45 @@ func (c *CardanoWalletApi) CreateTransaction(walletID string, req CreateTransact
 	return rawTx, tx, nil
 }
 
+// EstimateFee returns the highest fee cardano-wallet estimates for the
+// transaction.
+func (c *CardanoWalletApi) EstimateFee(ctx context.Context, walletID string, req CreateTransactionRequest) (fee uint64, err error) {
+	body, err := json.Marshal(paymentFeesRequest{
+		Payments:   req.Payments,
+		Withdrawal: req.Withdrawal,
+		Metadata:   req.Metadata,
+		TimeToLive: req.TimeToLive,
+	})
+	if err != nil {
+		return fee, err
+	}
+
+	resp, err := post(ctx, c.url+"/v2/wallets/"+walletID+"/payment-fees", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return fee, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fee, err
+	}
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fee, fmt.Errorf("fee not estimated: %s - %s", resp.Status, string(b))
+	}
+
+	var estimate FeeEstimate
+	if err = json.Unmarshal(b, &estimate); err != nil {
+		return fee, err
+	}
+
+	return estimate.EstimatedMax.Quantity, nil
+}
+
 // Get wallet by walletID
-func (c *CardanoWalletApi) GetWalletData(walletID string) (wallet WalletResponse, err error) {
-	resp, err := http.Get(c.url + "/v2/wallets/" + walletID)
+func (c *CardanoWalletApi) GetWalletData(ctx context.Context, walletID string) (wallet WalletResponse, err error) {
+	resp, err := get(ctx, c.url+"/v2/wallets/"+walletID)
 	if err != nil {
 		return wallet, err
 	}
@@ -173,8 +299,8 @@ func (c *CardanoWalletApi) GetWalletData(walletID string) (wallet WalletResponse
 	return wallet, nil
 }
 
-func (c *CardanoWalletApi) GetAddress(walletID string) (address string, err error) {
-	resp, err := http.Get(c.url + "/v2/wallets/" + walletID + "/addresses")
+func (c *CardanoWalletApi) GetAddress(ctx context.Context, walletID string) (address string, err error) {
+	resp, err := get(ctx, c.url+"/v2/wallets/"+walletID+"/addresses")
 	if err != nil {
 		return address, err
 	}
@@ -199,8 +325,8 @@ func (c *CardanoWalletApi) GetAddress(walletID string) (address string, err erro
 
 // --------------------------------------------------------
 
-func (c *CardanoWalletApi) GetToken(walletID, policyID, assetName string) (token WalletAsset, err error) {
-	resp, err := http.Get(c.url + "/v2/wallets/" + walletID + "/assets/" + policyID + "/" + assetName)
+func (c *CardanoWalletApi) GetToken(ctx context.Context, walletID, policyID, assetName string) (token WalletAsset, err error) {
+	resp, err := get(ctx, c.url+"/v2/wallets/"+walletID+"/assets/"+policyID+"/"+assetName)
 	if err != nil {
 		return token, err
 	}
@@ -235,13 +361,15 @@ func (c *CardanoWalletApi) GetToken(walletID, policyID, assetName string) (token
 // --------------------------------------------------------
 
 // Create and restore a wallet from a mnemonic sentence or account public key.
-func CreateWallet(url string, req CreateWalletRequest) (wallet WalletResponse, err error) {
+func CreateWallet(ctx context.Context, url string, req CreateWalletRequest) (wallet WalletResponse, err error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return wallet, err
 	}
 
-	resp, err := http.Post(url+"/v2/wallets", "application/json", bytes.NewBuffer(body))
+	slog.DebugContext(ctx, "Creating wallet", "request", req)
+
+	resp, err := post(ctx, url+"/v2/wallets", "application/json", bytes.NewBuffer(body))
 	if err != nil {
 		return wallet, err
 	}
@@ -252,6 +380,10 @@ func CreateWallet(url string, req CreateWalletRequest) (wallet WalletResponse, e
 		return wallet, err
 	}
 
+	if resp.StatusCode == http.StatusConflict {
+		return wallet, walletExists(b)
+	}
+
 	if resp.StatusCode != http.StatusCreated {
 		return wallet, fmt.Errorf("wallet not created: %s - %s", resp.Status, string(b))
 	}
@@ -263,9 +395,14 @@ func CreateWallet(url string, req CreateWalletRequest) (wallet WalletResponse, e
 	return wallet, nil
 }
 
-// --------------------------------------------------------
+const internalConfigFile = "config.json"
+
+// GetWalletsPasswords returns the wallets with their IDs and passphrases,
+// restoring the ones not restored yet from their mnemonic.
+func (c *CardanoWalletApi) GetWalletsPasswords(ctx context.Context, wallets map[string]config.WalletConfig) (fullWallet map[string]config.WalletConfig, err error) {
+	c.restoreMx.Lock()
+	defer c.restoreMx.Unlock()
 
-func GetWalletsPasswords(url string, wallets map[string]config.WalletConfig) (fullWallet map[string]config.WalletConfig, err error) {
 	internalConf := config.InternalConfig{
 		Wallets: make(map[string]config.InternalWalletConfig),
 	}
@@ -273,33 +410,59 @@ func GetWalletsPasswords(url string, wallets map[string]config.WalletConfig) (fu
 	fullWallet = make(map[string]config.WalletConfig)
 
 	// loads config from volume mounted to container
-	if err = goconfig.LoadConfig("/data/config.json", &internalConf); err != nil {
-		log.Println("No internal config found")
-		log.Println("Error loading config: ", err)
+	if err = goconfig.LoadConfig(c.internalConfig, &internalConf); err != nil {
+		slog.InfoContext(ctx, "No internal config found", "path", c.internalConfig, "error", err)
 	}
 
 	for i, wallet := range wallets {
 		iConf, ok := internalConf.Wallets[i]
 		if ok {
+			passphrase, err := secret.Lookup(c.secrets, iConf.WalletPassphrase)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error reading wallet passphrase", "wallet", i, "error", err)
+				continue
+			}
+
 			wallet.ID = iConf.WalletID
-			wallet.Passphrase = iConf.WalletPassphrase
+			wallet.Passphrase = passphrase
 			fullWallet[i] = wallet
 			continue
 		}
 
 		if wallet.Mnemonic != "" {
 			mnemonic := strings.Split(wallet.Mnemonic, " ")
-			passphrase := helpers.GeneratePassword(20, 1, 1, 1)
+
+			passphrase, err := c.newPassphrase(i)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error making wallet passphrase", "wallet", i, "error", err)
+				continue
+			}
+
+			// stored before the wallet is created, which can't spend without it
+			passphraseRef, err := c.storePassphrase(i, passphrase)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error storing wallet passphrase", "wallet", i, "error", err)
+				continue
+			}
 
 			// Create wallet
-			w, err := CreateWallet(url, CreateWalletRequest{
+			w, err := CreateWallet(ctx, c.url, CreateWalletRequest{
 				Name:           "wallet " + i,
 				Mnemonic:       mnemonic,
 				Passphrase:     passphrase,
-				AddressPoolGap: 20,
+				AddressPoolGap: c.addressPoolGap,
 			})
+			var exists *WalletExistsError
+			if errors.As(err, &exists) && c.passphrases.MasterSecret != "" {
+				// restored from this mnemonic before the state was lost, with
+				// the same derived passphrase
+				w, err = c.existingWallet(ctx, exists.ID)
+				if err == nil {
+					slog.InfoContext(ctx, "Recovered wallet with its derived passphrase", "wallet", i, "wallet_id", w.ID)
+				}
+			}
 			if err != nil {
-				log.Println("Error creating wallet: ", err)
+				slog.ErrorContext(ctx, "Error creating wallet", "wallet", i, "error", err)
 				continue
 			}
 
@@ -309,22 +472,105 @@ func GetWalletsPasswords(url string, wallets map[string]config.WalletConfig) (fu
 
 			internalConf.Wallets[i] = config.InternalWalletConfig{
 				WalletID:         w.ID,
-				WalletPassphrase: passphrase,
+				WalletPassphrase: passphraseRef,
 			}
 		}
 	}
 
-	if err = goconfig.SaveConfig("/data/config.json", internalConf); err != nil {
-		log.Println("Error saving config: ", err)
+	if err = goconfig.SaveConfig(c.internalConfig, internalConf); err != nil {
+		slog.ErrorContext(ctx, "Error saving internal config", "path", c.internalConfig, "error", err)
 	}
 
 	return fullWallet, nil
 }
 
+// newPassphrase returns the spending passphrase of a new wallet with the
+// config key: derived from the master secret when one is configured, random
+// otherwise.
+func (c *CardanoWalletApi) newPassphrase(key string) (string, error) {
+	if c.passphrases.MasterSecret == "" {
+		return helpers.GeneratePassword(c.passphrases.PasswordPolicy)
+	}
+
+	master, err := secret.Lookup(c.secrets, c.passphrases.MasterSecret)
+	if err != nil {
+		return "", fmt.Errorf("master secret: %w", err)
+	}
+
+	return helpers.DerivePassword(c.passphrases.PasswordPolicy, []byte(master), secret.WalletPassphrase(key))
+}
+
+// existingWallet returns the wallet cardano-wallet reported the mnemonic
+// restores to. Wallets are never matched by name, which any wallet can have.
+func (c *CardanoWalletApi) existingWallet(ctx context.Context, walletID string) (wallet WalletResponse, err error) {
+	if walletID == "" {
+		return wallet, fmt.Errorf("%w, but cardano-wallet didn't tell its ID", ErrWalletExists)
+	}
+
+	wallet, err = c.GetWalletData(ctx, walletID)
+	if err != nil {
+		return wallet, err
+	}
+
+	if wallet.ID != walletID {
+		return wallet, fmt.Errorf("cardano-wallet returned wallet %s for %s", wallet.ID, walletID)
+	}
+
+	return wallet, nil
+}
+
+// storePassphrase keeps the passphrase of a new wallet in the secret store
+// and returns the reference to it.
+func (c *CardanoWalletApi) storePassphrase(key, passphrase string) (ref string, err error) {
+	name := secret.WalletPassphrase(key)
+
+	if err = c.secrets.Set(name, passphrase); err != nil {
+		return "", err
+	}
+
+	return secret.Ref(name), nil
+}
+
+// MigratePassphrases moves the passphrases kept in the internal config in
+// the state dir to the secret store, leaving references to them.
+func MigratePassphrases(stateDir string, store secret.Store) (migrated []string, err error) {
+	path := filepath.Join(stateDir, internalConfigFile)
+
+	internalConf := config.InternalConfig{}
+	if err = goconfig.LoadConfig(path, &internalConf); err != nil {
+		return nil, fmt.Errorf("loading %s: %w", path, err)
+	}
+
+	for key, w := range internalConf.Wallets {
+		if w.WalletPassphrase == "" || config.IsSecretRef(w.WalletPassphrase) {
+			continue
+		}
+
+		name := secret.WalletPassphrase(key)
+		if err = store.Set(name, w.WalletPassphrase); err != nil {
+			return migrated, fmt.Errorf("wallet %s: %w", key, err)
+		}
+
+		w.WalletPassphrase = secret.Ref(name)
+		internalConf.Wallets[key] = w
+		migrated = append(migrated, key)
+	}
+
+	if len(migrated) == 0 {
+		return nil, nil
+	}
+
+	if err = goconfig.SaveConfig(path, internalConf); err != nil {
+		return migrated, fmt.Errorf("saving %s: %w", path, err)
+	}
+
+	return migrated, nil
+}
+
 // --------------------------------------------------------
 
-func (c *CardanoWalletApi) GetWalletNetworkInformation() (info NetworkInfo, err error) {
-	resp, err := http.Get(c.url + "/v2/network/information")
+func (c *CardanoWalletApi) GetWalletNetworkInformation(ctx context.Context) (info NetworkInfo, err error) {
+	resp, err := get(ctx, c.url+"/v2/network/information")
 	if err != nil {
 		return info, err
 	}
@@ -347,8 +593,8 @@ func (c *CardanoWalletApi) GetWalletNetworkInformation() (info NetworkInfo, err
 }
 
 // Return a list of known wallets, ordered from oldest to newest.
-func (c *CardanoWalletApi) GetListWallets() (wallets Wallets, err error) {
-	resp, err := http.Get(c.url + "/v2/wallets")
+func (c *CardanoWalletApi) GetListWallets(ctx context.Context) (wallets Wallets, err error) {
+	resp, err := get(ctx, c.url+"/v2/wallets")
 	if err != nil {
 		return wallets, err
 	}
@@ -0,0 +1,37 @@
+package cwalletapi
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWalletExists(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "conflict",
+			body: `{"code":"wallet_already_exists","message":"This operation would yield a wallet with the following id: 2512a00e9653fe49a44a5886202e24d77eeb998f However, I already know of a wallet with this id."}`,
+			want: "2512a00e9653fe49a44a5886202e24d77eeb998f",
+		},
+		{name: "no ID", body: `{"code":"wallet_already_exists","message":"I already know of this wallet."}`},
+		{name: "not JSON", body: `2512a00e9653fe49a44a5886202e24d77eeb998f`},
+		{name: "longer hex", body: `{"message":"id: 2512a00e9653fe49a44a5886202e24d77eeb998f00"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := walletExists([]byte(tt.body))
+
+			if !errors.Is(err, ErrWalletExists) {
+				t.Fatalf("err = %v, want ErrWalletExists", err)
+			}
+
+			if err.ID != tt.want {
+				t.Fatalf("ID = %q, want %q", err.ID, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package cwalletapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/logging"
+)
+
+// get and post call cardano-wallet with the request ID of ctx, so its calls
+// can be matched with the RPC that made them.
+func get(ctx context.Context, url string) (*http.Response, error) {
+	return do(ctx, http.MethodGet, url, "", nil)
+}
+
+func post(ctx context.Context, url, contentType string, body io.Reader) (*http.Response, error) {
+	return do(ctx, http.MethodPost, url, contentType, body)
+}
+
+func do(ctx context.Context, method, url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+
+	start := time.Now()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		slog.WarnContext(ctx, "cardano-wallet call failed",
+			"method", method, "path", req.URL.Path, "error", err)
+		return nil, err
+	}
+
+	slog.DebugContext(ctx, "cardano-wallet call",
+		"method", method, "path", req.URL.Path, "status", resp.StatusCode, "duration", time.Since(start))
+
+	return resp, nil
+}
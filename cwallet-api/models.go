@@ -1,5 +1,11 @@
 package cwalletapi
 
+import (
+	"log/slog"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/logging"
+)
+
 type decodeTxRequest struct {
 	Transaction string `json:"transaction"`
 }
@@ -11,6 +17,16 @@ type RestoreWalletRequest struct {
 	AddressPoolGap uint64   `json:"address_pool_gap"`
 }
 
+// LogValue logs the request with its mnemonic and passphrase redacted.
+func (r RestoreWalletRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("name", r.Name),
+		slog.Any("mnemonic_sentence", logging.Secret("")),
+		slog.Any("passphrase", logging.Secret("")),
+		slog.Uint64("address_pool_gap", r.AddressPoolGap),
+	)
+}
+
 type WalletResponse struct {
 	ID             string     `json:"id"`
 	AddressPoolGap uint64     `json:"address_pool_gap"`
@@ -175,6 +191,28 @@ type CreateTransactionRequest struct {
 	TimeToLive Quantity  `json:"time_to_live"`
 }
 
+// LogValue logs the request with its passphrase redacted.
+func (r CreateTransactionRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Any("passphrase", logging.Secret("")),
+		slog.Any("payments", r.Payments),
+		slog.String("withdrawal", r.Withdrawal),
+		slog.Uint64("time_to_live", r.TimeToLive.Quantity),
+	)
+}
+
+type paymentFeesRequest struct {
+	Payments   []Payment `json:"payments"`
+	Withdrawal string    `json:"withdrawal,omitempty"`
+	Metadata   Metadata  `json:"metadata,omitempty"`
+	TimeToLive Quantity  `json:"time_to_live"`
+}
+
+type FeeEstimate struct {
+	EstimatedMin Quantity `json:"estimated_min"`
+	EstimatedMax Quantity `json:"estimated_max"`
+}
+
 type Payment struct {
 	Address        string   `json:"address"`
 	Amount         Quantity `json:"amount"`
@@ -216,6 +254,10 @@ type WalletAsset struct {
 
 	TotalQuantity uint64 `json:"total_quantity"`
 
+	// RemainingLots is how many lots the total cap of the sale still allows,
+	// nil without one.
+	RemainingLots *uint64 `json:"remaining_lots,omitempty"`
+
 	RewardAddress string `json:"reward_address"`
 }
 
@@ -242,6 +284,16 @@ type CreateWalletRequest struct {
 	AddressPoolGap uint64 `json:"address_pool_gap"`
 }
 
+// LogValue logs the request with its mnemonic and passphrase redacted.
+func (r CreateWalletRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("name", r.Name),
+		slog.Any("mnemonic_sentence", logging.Secret("")),
+		slog.Any("passphrase", logging.Secret("")),
+		slog.Uint64("address_pool_gap", r.AddressPoolGap),
+	)
+}
+
 // --------------------------------------------------------
 
 type NetworkInfo struct {
@@ -0,0 +1,170 @@
+// Package events is an in-process bus of wallet events.
+//
+// Every event gets the next sequence number and the epoch of the bus, which
+// is new in every process. The bus keeps the latest events, so a subscriber
+// that reconnects can resume after the last epoch and sequence it saw without
+// missing any, as long as they are still kept and the process hasn't
+// restarted.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	TypeWalletStatus   = "wallet_status_changed"
+	TypeBalanceChanged = "balance_changed"
+	TypeAssetDepleted  = "asset_depleted"
+	TypePayoutRollback = "payout_rollback"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it
+// is dropped.
+const subscriberBuffer = 64
+
+var (
+	// ErrGap is returned when events after the requested sequence are no
+	// longer kept, or the sequence is from another epoch, before a restart.
+	ErrGap = errors.New("events after this sequence are no longer available")
+
+	// ErrUnknownSequence is returned for a sequence of the current epoch the
+	// bus hasn't reached.
+	ErrUnknownSequence = errors.New("unknown sequence")
+)
+
+type Event struct {
+	Epoch string    `json:"epoch"`
+	Seq   uint64    `json:"seq"`
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+
+	WalletID   string `json:"wallet_id,omitempty"`
+	WalletName string `json:"wallet_name,omitempty"`
+
+	// Status changes carry the previous and new wallet status.
+	PreviousStatus string `json:"previous_status,omitempty"`
+	Status         string `json:"status,omitempty"`
+
+	// Balance changes carry the previous and new available lovelace.
+	PreviousLovelace uint64 `json:"previous_lovelace,omitempty"`
+	Lovelace         uint64 `json:"lovelace,omitempty"`
+
+	PolicyID string `json:"policy_id,omitempty"`
+	AssetID  string `json:"asset_id,omitempty"`
+	Quantity uint64 `json:"quantity,omitempty"`
+
+	TxID    string `json:"tx_id,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type Bus struct {
+	mx *sync.Mutex
+
+	epoch   string
+	seq     uint64
+	history []Event // the latest events, oldest first
+	keep    int
+
+	subscribers map[chan Event]struct{}
+}
+
+// NewBus returns a bus that keeps the latest keep events for resuming
+// subscribers.
+func NewBus(keep int) *Bus {
+	return &Bus{
+		mx:          &sync.Mutex{},
+		epoch:       newEpoch(),
+		keep:        keep,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// newEpoch returns a random epoch, so sequences of different processes never
+// match.
+func newEpoch() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// Publish numbers the event and sends it to every subscriber. Subscribers
+// that fell too far behind are dropped; they can resume from the last
+// sequence they received.
+func (b *Bus) Publish(e Event) Event {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.seq++
+	e.Epoch, e.Seq = b.epoch, b.seq
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	b.history = append(b.history, e)
+	if len(b.history) > b.keep {
+		b.history = b.history[len(b.history)-b.keep:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return e
+}
+
+// Subscribe returns the kept events after the sequence after of the epoch,
+// and a channel of the events published from then on. A zero after subscribes
+// to new events only. The channel is closed when the subscriber falls behind.
+// cancel ends the subscription.
+func (b *Bus) Subscribe(epoch string, after uint64) (backlog []Event, events <-chan Event, cancel func(), err error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if after > 0 && epoch != b.epoch {
+		return nil, nil, nil, ErrGap
+	}
+
+	if after > b.seq {
+		return nil, nil, nil, ErrUnknownSequence
+	}
+
+	if after > 0 && after < b.seq {
+		if len(b.history) == 0 || b.history[0].Seq > after+1 {
+			return nil, nil, nil, ErrGap
+		}
+
+		for _, e := range b.history {
+			if e.Seq > after {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+
+	cancel = func() {
+		b.mx.Lock()
+		defer b.mx.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return backlog, ch, cancel, nil
+}
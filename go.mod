@@ -1,6 +1,6 @@
 module github.com/intellisoftalpin/cardano-wallet-backend
 
-go 1.19
+go 1.21
 
 require (
 	github.com/intellisoftalpin/proto v0.0.5
@@ -17,5 +17,5 @@ require (
 	golang.org/x/net v0.14.0 // indirect
 	golang.org/x/sys v0.11.0 // indirect
 	golang.org/x/text v0.12.0 // indirect
-	google.golang.org/protobuf v1.31.0 // indirect
+	google.golang.org/protobuf v1.31.0
 )
@@ -1,49 +1,288 @@
 package helpers
 
 import (
-	"math/rand"
-	"strings"
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
 )
 
-var (
-	lowerCharSet   = "abcdedfghijklmnopqrst"
-	upperCharSet   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	specialCharSet = "!@#$%&*"
-	numberSet      = "0123456789"
-	allCharSet     = lowerCharSet + upperCharSet + specialCharSet + numberSet
+// Character classes of a password policy.
+const (
+	ClassLower   = "lower"
+	ClassUpper   = "upper"
+	ClassNumber  = "number"
+	ClassSpecial = "special"
 )
 
-func GeneratePassword(passwordLength, minSpecialChar, minNum, minUpperCase int) string {
-	var password strings.Builder
+var charSets = map[string]string{
+	ClassLower:   "abcdefghijklmnopqrstuvwxyz",
+	ClassUpper:   "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	ClassNumber:  "0123456789",
+	ClassSpecial: "!@#$%&*",
+}
+
+// MinMasterSecretLength is the least length in bytes of the master secret
+// passwords are derived from.
+const MinMasterSecretLength = 32
+
+// minAcceptance is the least chance a password drawn from the whole alphabet
+// meets the policy. Stricter policies would take too many draws.
+const minAcceptance = 1e-3
+
+// derivationLabel separates derived passwords from other uses of the master
+// secret.
+const derivationLabel = "cardano-wallet-backend/password/v1"
+
+// PasswordPolicy is what a generated password looks like: Length characters
+// from the Classes, at least MinPerClass of each, with at least
+// MinEntropyBits of entropy.
+type PasswordPolicy struct {
+	Length         int      `json:"length"`
+	Classes        []string `json:"classes"`
+	MinPerClass    int      `json:"min_per_class"`
+	MinEntropyBits float64  `json:"min_entropy_bits"`
+}
+
+// DefaultPasswordPolicy returns the policy of wallet passphrases.
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		Length:         24,
+		Classes:        []string{ClassLower, ClassUpper, ClassNumber, ClassSpecial},
+		MinPerClass:    1,
+		MinEntropyBits: 128,
+	}
+}
+
+// Validate checks that passwords can be generated under the policy and have
+// the entropy it asks for.
+func (p PasswordPolicy) Validate() error {
+	if p.Length < 1 {
+		return fmt.Errorf("length must be positive, got %d", p.Length)
+	}
+
+	if len(p.Classes) == 0 {
+		return fmt.Errorf("no character classes")
+	}
+
+	seen := make(map[string]bool)
+	for _, class := range p.Classes {
+		if _, ok := charSets[class]; !ok {
+			return fmt.Errorf("unknown character class %q", class)
+		}
+
+		if seen[class] {
+			return fmt.Errorf("character class %q is listed twice", class)
+		}
+		seen[class] = true
+	}
+
+	if p.MinPerClass < 0 {
+		return fmt.Errorf("min_per_class must not be negative, got %d", p.MinPerClass)
+	}
+
+	if p.MinPerClass*len(p.Classes) > p.Length {
+		return fmt.Errorf("%d characters of each of %d classes don't fit in %d", p.MinPerClass, len(p.Classes), p.Length)
+	}
+
+	count := p.count()
 
-	//Set special character
-	for i := 0; i < minSpecialChar; i++ {
-		random := rand.Intn(len(specialCharSet))
-		password.WriteString(string(specialCharSet[random]))
+	total := new(big.Int).Exp(big.NewInt(int64(len(p.alphabet()))), big.NewInt(int64(p.Length)), nil)
+	if log2(count)-log2(total) < math.Log2(minAcceptance) {
+		return fmt.Errorf("min_per_class %d is too strict for length %d", p.MinPerClass, p.Length)
 	}
 
-	//Set numeric
-	for i := 0; i < minNum; i++ {
-		random := rand.Intn(len(numberSet))
-		password.WriteString(string(numberSet[random]))
+	if entropy := log2(count); entropy < p.MinEntropyBits {
+		return fmt.Errorf("passwords have %.1f bits of entropy, below the minimum of %.1f", entropy, p.MinEntropyBits)
 	}
 
-	//Set uppercase
-	for i := 0; i < minUpperCase; i++ {
-		random := rand.Intn(len(upperCharSet))
-		password.WriteString(string(upperCharSet[random]))
+	return nil
+}
+
+// Entropy returns the entropy in bits of the passwords of the policy. Every
+// password meeting the policy is generated with the same chance, so it is
+// log2 of how many there are.
+func (p PasswordPolicy) Entropy() float64 {
+	return log2(p.count())
+}
+
+func (p PasswordPolicy) alphabet() (alphabet string) {
+	for _, class := range p.Classes {
+		alphabet += charSets[class]
 	}
 
-	remainingLength := passwordLength - minSpecialChar - minNum - minUpperCase
-	for i := 0; i < remainingLength; i++ {
-		random := rand.Intn(len(allCharSet))
-		password.WriteString(string(allCharSet[random]))
+	return alphabet
+}
+
+// count returns how many passwords meet the policy. ways[n] counts the
+// strings of n characters from the classes so far with at least MinPerClass
+// of each; each class adds k of its characters at any of the positions.
+func (p PasswordPolicy) count() *big.Int {
+	ways := make([]*big.Int, p.Length+1)
+	ways[0] = big.NewInt(1)
+
+	for _, class := range p.Classes {
+		size := big.NewInt(int64(len(charSets[class])))
+
+		next := make([]*big.Int, p.Length+1)
+		for n, w := range ways {
+			if w == nil {
+				continue
+			}
+
+			for k := p.MinPerClass; n+k <= p.Length; k++ {
+				c := new(big.Int).Binomial(int64(n+k), int64(k))
+				c.Mul(c, new(big.Int).Exp(size, big.NewInt(int64(k)), nil))
+				c.Mul(c, w)
+
+				if next[n+k] == nil {
+					next[n+k] = new(big.Int)
+				}
+				next[n+k].Add(next[n+k], c)
+			}
+		}
+
+		ways = next
+	}
+
+	if ways[p.Length] == nil {
+		return new(big.Int)
+	}
+
+	return ways[p.Length]
+}
+
+func log2(n *big.Int) float64 {
+	if n.Sign() == 0 {
+		return math.Inf(-1)
 	}
 
-	inRune := []rune(password.String())
-	rand.Shuffle(len(inRune), func(i, j int) {
-		inRune[i], inRune[j] = inRune[j], inRune[i]
-	})
+	mant := new(big.Float)
+	exp := new(big.Float).SetInt(n).MantExp(mant)
+	m, _ := mant.Float64()
+
+	return math.Log2(m) + float64(exp)
+}
+
+// ----------------------------------------------------------------------
+
+// GeneratePassword returns a random password meeting the policy.
+func GeneratePassword(policy PasswordPolicy) (string, error) {
+	return generate(policy, bufio.NewReader(rand.Reader))
+}
+
+// DerivePassword returns the password of the policy derived from the master
+// secret for context, the same every time, so a lost password can be derived
+// again. Changing the policy changes the password.
+func DerivePassword(policy PasswordPolicy, master []byte, context string) (string, error) {
+	if len(master) < MinMasterSecretLength {
+		return "", fmt.Errorf("master secret must be at least %d bytes", MinMasterSecretLength)
+	}
+
+	return generate(policy, &hmacStream{key: master, context: context})
+}
+
+// generate draws passwords from the alphabet of the policy until one meets
+// it, so every password meeting it is as likely.
+func generate(policy PasswordPolicy, r io.Reader) (string, error) {
+	if err := policy.Validate(); err != nil {
+		return "", err
+	}
+
+	alphabet := policy.alphabet()
+	password := make([]byte, policy.Length)
+
+	for {
+		for i := range password {
+			n, err := uniform(r, len(alphabet))
+			if err != nil {
+				return "", err
+			}
+
+			password[i] = alphabet[n]
+		}
+
+		if policy.meets(password) {
+			return string(password), nil
+		}
+	}
+}
+
+// uniform returns a number in [0, n) read from r, n at most 256. Bytes above
+// the largest multiple of n are dropped so no number is more likely.
+func uniform(r io.Reader, n int) (int, error) {
+	limit := 256 - 256%n
+
+	var b [1]byte
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+
+		if int(b[0]) < limit {
+			return int(b[0]) % n, nil
+		}
+	}
+}
+
+func (p PasswordPolicy) meets(password []byte) bool {
+	for _, class := range p.Classes {
+		n := 0
+		for _, c := range password {
+			if containsByte(charSets[class], c) {
+				n++
+			}
+		}
+
+		if n < p.MinPerClass {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsByte(s string, c byte) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hmacStream is an endless stream of HMAC-SHA256 blocks of the context and a
+// counter, keyed with the master secret.
+type hmacStream struct {
+	key     []byte
+	context string
+	counter uint64
+	buf     []byte
+}
+
+func (s *hmacStream) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if len(s.buf) == 0 {
+			mac := hmac.New(sha256.New, s.key)
+			mac.Write([]byte(derivationLabel))
+			mac.Write([]byte{0})
+			mac.Write([]byte(s.context))
+			binary.Write(mac, binary.BigEndian, s.counter)
+
+			s.buf = mac.Sum(nil)
+			s.counter++
+		}
+
+		c := copy(p[n:], s.buf)
+		s.buf = s.buf[c:]
+		n += c
+	}
 
-	return string(inRune)
+	return n, nil
 }
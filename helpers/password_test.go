@@ -0,0 +1,225 @@
+package helpers
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+var testPolicies = []PasswordPolicy{
+	DefaultPasswordPolicy(),
+	{Length: 32, Classes: []string{ClassLower, ClassUpper, ClassNumber, ClassSpecial}, MinPerClass: 4, MinEntropyBits: 128},
+	{Length: 30, Classes: []string{ClassLower, ClassNumber}, MinPerClass: 3, MinEntropyBits: 100},
+	{Length: 28, Classes: []string{ClassLower}, MinPerClass: 0, MinEntropyBits: 128},
+}
+
+var testMaster = bytes.Repeat([]byte("master secret "), 3)
+
+// checkPassword checks that the password meets the policy: its length, its
+// alphabet and the least characters of each class.
+func checkPassword(t *testing.T, policy PasswordPolicy, password string) {
+	t.Helper()
+
+	if len(password) != policy.Length {
+		t.Fatalf("password %q has length %d, want %d", password, len(password), policy.Length)
+	}
+
+	alphabet := policy.alphabet()
+	for _, c := range []byte(password) {
+		if !containsByte(alphabet, c) {
+			t.Fatalf("password %q has %q, not in the policy's classes", password, c)
+		}
+	}
+
+	for _, class := range policy.Classes {
+		n := 0
+		for _, c := range []byte(password) {
+			if containsByte(charSets[class], c) {
+				n++
+			}
+		}
+
+		if n < policy.MinPerClass {
+			t.Fatalf("password %q has %d %s characters, want at least %d", password, n, class, policy.MinPerClass)
+		}
+	}
+}
+
+func TestGeneratePasswordMeetsPolicy(t *testing.T) {
+	for _, policy := range testPolicies {
+		t.Run(fmt.Sprint(policy), func(t *testing.T) {
+			if entropy := policy.Entropy(); entropy < policy.MinEntropyBits {
+				t.Fatalf("policy has %.1f bits of entropy, below its floor of %.1f", entropy, policy.MinEntropyBits)
+			}
+
+			for i := 0; i < 200; i++ {
+				password, err := GeneratePassword(policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				checkPassword(t, policy, password)
+			}
+		})
+	}
+}
+
+func TestDerivePasswordMeetsPolicy(t *testing.T) {
+	for _, policy := range testPolicies {
+		t.Run(fmt.Sprint(policy), func(t *testing.T) {
+			for i := 0; i < 200; i++ {
+				password, err := DerivePassword(policy, testMaster, fmt.Sprintf("wallets/%d/passphrase", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				checkPassword(t, policy, password)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyEntropyFloor(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+	policy.MinEntropyBits = policy.Entropy() + 1
+
+	if err := policy.Validate(); err == nil {
+		t.Fatal("Validate accepted a policy below its entropy floor")
+	}
+
+	if _, err := GeneratePassword(policy); err == nil {
+		t.Fatal("GeneratePassword accepted a policy below its entropy floor")
+	}
+}
+
+// chiSquareLimit returns the chi-square value with df degrees of freedom
+// exceeded with a chance of about 1e-5, by the Wilson-Hilferty approximation.
+func chiSquareLimit(df int) float64 {
+	const z = 4.265
+
+	k := float64(df)
+	a := 2 / (9 * k)
+
+	return k * math.Pow(1-a+z*math.Sqrt(a), 3)
+}
+
+// checkFrequencies checks with a chi-square test that the characters of each
+// class are equally likely. Classes as a whole aren't, since passwords short
+// of a class are drawn again.
+func checkFrequencies(t *testing.T, policy PasswordPolicy, passwords []string) {
+	t.Helper()
+
+	counts := make(map[byte]int)
+	for _, password := range passwords {
+		for _, c := range []byte(password) {
+			counts[c]++
+		}
+	}
+
+	for _, class := range policy.Classes {
+		chars := charSets[class]
+
+		total := 0
+		for i := 0; i < len(chars); i++ {
+			total += counts[chars[i]]
+		}
+
+		expected := float64(total) / float64(len(chars))
+
+		chi2 := 0.0
+		for i := 0; i < len(chars); i++ {
+			d := float64(counts[chars[i]]) - expected
+			chi2 += d * d / expected
+		}
+
+		if limit := chiSquareLimit(len(chars) - 1); chi2 > limit {
+			t.Errorf("%s characters: chi-square %.1f above %.1f, counts %v", class, chi2, limit, counts)
+		}
+	}
+}
+
+func TestGeneratePasswordFrequencies(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+
+	passwords := make([]string, 2000)
+	for i := range passwords {
+		password, err := GeneratePassword(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		passwords[i] = password
+	}
+
+	checkFrequencies(t, policy, passwords)
+}
+
+func TestDerivePasswordFrequencies(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+
+	passwords := make([]string, 2000)
+	for i := range passwords {
+		password, err := DerivePassword(policy, testMaster, fmt.Sprintf("wallets/%d/passphrase", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		passwords[i] = password
+	}
+
+	checkFrequencies(t, policy, passwords)
+}
+
+func TestDerivePasswordDeterministic(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+
+	derive := func(master []byte, context string) string {
+		t.Helper()
+
+		password, err := DerivePassword(policy, master, context)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return password
+	}
+
+	first := derive(testMaster, "wallets/1/passphrase")
+
+	if again := derive(testMaster, "wallets/1/passphrase"); again != first {
+		t.Fatalf("derived %q, then %q for the same master secret and wallet", first, again)
+	}
+
+	if other := derive(testMaster, "wallets/2/passphrase"); other == first {
+		t.Fatalf("derived %q for two wallets", first)
+	}
+
+	otherMaster := append(bytes.Clone(testMaster), '!')
+	if other := derive(otherMaster, "wallets/1/passphrase"); other == first {
+		t.Fatalf("derived %q for two master secrets", first)
+	}
+}
+
+// Wallets restored with a derived passphrase are recovered by deriving it
+// again, so the derivation must never change.
+func TestDerivePasswordStable(t *testing.T) {
+	password, err := DerivePassword(DefaultPasswordPolicy(), testMaster, "wallets/1/passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const want = "*9YN71a!ifzhSoB9Qa6AiVfO"
+	if password != want {
+		t.Fatalf("derived %q, want %q", password, want)
+	}
+}
+
+func TestDerivePasswordShortMaster(t *testing.T) {
+	master := []byte(strings.Repeat("x", MinMasterSecretLength-1))
+
+	if _, err := DerivePassword(DefaultPasswordPolicy(), master, "wallets/1/passphrase"); err == nil {
+		t.Fatal("DerivePassword accepted a short master secret")
+	}
+}
@@ -0,0 +1,129 @@
+// Package logging sets up structured logging with log/slog.
+//
+// Records are written as JSON by default. Attributes named like secrets are
+// redacted whatever their value, on top of the types that redact themselves
+// with slog.LogValuer. A request ID put in the context with WithRequestID is
+// added to every record logged with that context.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Redacted replaces secret values in logs.
+const Redacted = "[redacted]"
+
+// secretKeys are attribute keys whose values are always redacted.
+var secretKeys = map[string]bool{
+	"mnemonic":          true,
+	"mnemonic_sentence": true,
+	"passphrase":        true,
+	"wallet_passphrase": true,
+	"secret":            true,
+	"vault_token":       true,
+}
+
+// Setup makes a logger writing to w in format at level the default, for
+// slog and for the log package.
+func Setup(w io.Writer, format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("log level: %w", err)
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       lvl,
+		ReplaceAttr: redact,
+	}
+
+	var handler slog.Handler
+
+	switch format {
+	case "", FormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	case FormatText:
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+
+	slog.SetDefault(slog.New(contextHandler{handler}))
+
+	return nil
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if secretKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+
+	return a
+}
+
+// Secret is a string that is redacted when logged.
+type Secret string
+
+func (Secret) LogValue() slog.Value {
+	return slog.StringValue(Redacted)
+}
+
+// ----------------------------------------------------------------------
+
+type requestIDKey struct{}
+
+// RequestIDHeader carries the request ID in gRPC metadata and outbound HTTP
+// calls.
+const RequestIDHeader = "x-request-id"
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a context carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of the context, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request ID of the context to records.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -1,7 +1,18 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/grpclog"
@@ -9,24 +20,79 @@ import (
 	walletPB "github.com/intellisoftalpin/proto/proto-gen/wallet"
 
 	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+	"github.com/intellisoftalpin/cardano-wallet-backend/logging"
+	"github.com/intellisoftalpin/cardano-wallet-backend/secret"
 	"github.com/intellisoftalpin/cardano-wallet-backend/wallet"
 )
 
+// commands of the binary, serve is run when none is given
+var commands = map[string]func(flags config.Flags) int{
+	"serve":           serve,
+	"validate-config": validateConfig,
+	"print-config":    printConfig,
+	"migrate-secrets": migrateSecrets,
+}
+
 func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown command:", name)
+		fmt.Fprintln(os.Stderr, "commands: serve, validate-config, print-config, migrate-secrets")
+		os.Exit(2)
+	}
+
+	flags, err := config.ParseFlags(name, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		os.Exit(2)
+	}
+
+	os.Exit(command(flags))
+}
+
+// serve runs the gRPC server until SIGINT or SIGTERM.
+func serve(flags config.Flags) int {
 	// grpc.EnableTracing = true
 
-	loadedConfig, err := config.LoadConfig()
+	loadedConfig, err := config.LoadConfig(flags)
 	if err != nil {
 		panic(err)
 	}
 
+	if err = logging.Setup(os.Stdout, loadedConfig.Log.Format, loadedConfig.Log.Level); err != nil {
+		panic(err)
+	}
+
+	slog.Info("Loaded config", "config", loadedConfig)
+
+	secrets, err := secret.NewStore(loadedConfig.Secrets, loadedConfig.StateDir)
+	if err != nil {
+		panic(err)
+	}
+
+	if err = secret.Resolve(secrets, loadedConfig); err != nil {
+		panic(err)
+	}
+
 	listener, err := net.Listen("tcp", ":"+loadedConfig.ServerPort)
 	if err != nil {
 		grpclog.Fatalf("failed to listen: %v", err)
 	}
 
 	// ----------------------------------------------------------------------
-	var opts []grpc.ServerOption
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(wallet.UnaryInterceptor),
+		grpc.StreamInterceptor(wallet.StreamInterceptor),
+	}
 
 	// creds, err := cert.SetupTLS(loadedConfig.TLS)
 	// if err != nil {
@@ -34,12 +100,174 @@ func main() {
 	// 	return
 	// }
 
-	// opts = []grpc.ServerOption{grpc.Creds(creds)}
+	// opts = append(opts, grpc.Creds(creds))
 
 	grpcServer := grpc.NewServer(opts...)
 
 	// ----------------------------------------------------------------------
 
-	walletPB.RegisterWalletServer(grpcServer, wallet.NewServer(loadedConfig))
-	grpcServer.Serve(listener)
+	walletServer := wallet.NewServer(loadedConfig, secrets)
+
+	walletPB.RegisterWalletServer(grpcServer, walletServer)
+
+	// the admin service has a listener of its own, local by default
+	var adminServer *grpc.Server
+	if loadedConfig.Admin.Enabled {
+		adminServer, err = wallet.NewAdminGRPCServer(loadedConfig.Admin, wallet.NewAdminServer(walletServer.TransactionRepo))
+		if err != nil {
+			panic(err)
+		}
+
+		adminListener, err := net.Listen("tcp", loadedConfig.Admin.Address)
+		if err != nil {
+			grpclog.Fatalf("failed to listen for admin: %v", err)
+		}
+
+		go func() {
+			if err := adminServer.Serve(adminListener); err != nil {
+				slog.Error("Admin server stopped", "error", err)
+			}
+		}()
+	}
+
+	// background workers and the server stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	walletServer.TransactionRepo.Start(ctx)
+
+	// config file changes and SIGHUP apply to the running wallets
+	go config.Watch(ctx, loadedConfig, func(next *config.Config) {
+		if err := secret.Resolve(secrets, next); err != nil {
+			slog.Error("Rejected config, keeping the running one", "error", err)
+			return
+		}
+
+		if err := logging.Setup(os.Stdout, next.Log.Format, next.Log.Level); err != nil {
+			slog.Error("Error applying log config", "error", err)
+		}
+
+		walletServer.TransactionRepo.ApplyConfig(next)
+	})
+
+	go func() {
+		<-ctx.Done()
+		grpcServer.GracefulStop()
+
+		if adminServer != nil {
+			adminServer.GracefulStop()
+		}
+	}()
+
+	if err = grpcServer.Serve(listener); err != nil {
+		grpclog.Fatalf("failed to serve: %v", err)
+	}
+
+	walletServer.TransactionRepo.Wait()
+
+	return 0
+}
+
+// validateConfig checks the config the server would start with and prints
+// every problem found, for CI and pre-deploy checks.
+func validateConfig(flags config.Flags) int {
+	loadedConfig, err := config.Load(flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var errs config.ValidationErrors
+	if err = loadedConfig.Validate(); errors.As(err, &errs) {
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, e)
+		}
+
+		fmt.Fprintf(os.Stderr, "%d config errors\n", len(errs))
+		return 1
+	}
+
+	fmt.Println("config OK:", loadedConfig.ConfigPath)
+	return 0
+}
+
+// printConfig prints the effective config, after flags, environment and
+// file are layered and defaults filled in, with its secrets redacted.
+func printConfig(flags config.Flags) int {
+	loadedConfig, err := config.Load(flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	effective := struct {
+		EnvFile    string `json:"env_file"`
+		ConfigPath string `json:"config_path"`
+		*config.Config
+	}{
+		EnvFile:    loadedConfig.EnvFile,
+		ConfigPath: loadedConfig.ConfigPath,
+		Config:     loadedConfig.Redacted(),
+	}
+
+	b, err := json.MarshalIndent(effective, "", "    ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(string(b))
+	return 0
+}
+
+// migrateSecrets moves the secrets kept in plain text to the configured
+// secret store: the passphrases of restored wallets, which are replaced by
+// references in the state dir, and the mnemonics of the config file, whose
+// references are printed to replace them with.
+func migrateSecrets(flags config.Flags) int {
+	loadedConfig, err := config.Load(flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	secrets, err := secret.NewStore(loadedConfig.Secrets, loadedConfig.StateDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	migrated, err := cwalletapi.MigratePassphrases(loadedConfig.StateDir, secrets)
+	for _, key := range migrated {
+		fmt.Println("moved the passphrase of wallet", key, "to", loadedConfig.Secrets.Backend)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	keys := make([]string, 0, len(loadedConfig.Wallets))
+	for key := range loadedConfig.Wallets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		w := loadedConfig.Wallets[key]
+		if w.Mnemonic == "" || config.IsSecretRef(w.Mnemonic) {
+			continue
+		}
+
+		name := secret.WalletMnemonic(key)
+		if err = secrets.Set(name, w.Mnemonic); err != nil {
+			fmt.Fprintln(os.Stderr, "wallet", key, ":", err)
+			return 1
+		}
+
+		fmt.Printf("stored the mnemonic of wallet %s, set wallets.%s.mnemonic_sentence to %q in %s\n",
+			key, key, secret.Ref(name), loadedConfig.ConfigPath)
+	}
+
+	return 0
 }
@@ -0,0 +1,44 @@
+package metadata
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalid matches every ValidationError with errors.Is.
+var ErrInvalid = errors.New("invalid metadata")
+
+// FieldError is a problem with a single metadata field.
+type FieldError struct {
+	Label   string
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Label + " " + e.Field + ": " + e.Message
+}
+
+// ValidationError lists every invalid field of purchase metadata.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldErr := range e {
+		messages = append(messages, fieldErr.Error())
+	}
+
+	return ErrInvalid.Error() + ": " + strings.Join(messages, "; ")
+}
+
+func (e ValidationError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+func (e *ValidationError) add(label, field, message string) {
+	*e = append(*e, FieldError{
+		Label:   label,
+		Field:   field,
+		Message: message,
+	})
+}
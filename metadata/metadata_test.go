@@ -0,0 +1,373 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+)
+
+const (
+	testPolicyID = "d5e6bf0500378d4f0da4e8dde6becec7621cd8cbf5cbb9b87013d4cc"
+	testAssetID  = "537061636542756433"
+	testPolicy2  = "8a1cfae21368b8bebbbed9800fec304e95cce39a2a57dc35e2e3ebaa"
+	testAsset2   = "4d494c4b"
+
+	// a base address longer than one metadata string
+	testAddress       = "addr1qx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzer3n0d3vllmyqwsx5wktcd8cc3sq835lu7drv2xwl2wywfgse35a3x"
+	testRefundAddress = "addr1vx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzers66hrl8"
+)
+
+// decodeJSON decodes purchase metadata as cardano-wallet returns it.
+func decodeJSON(t *testing.T, s string) (PurchaseRequest, error) {
+	t.Helper()
+
+	var m cwalletapi.Metadata
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return PurchaseRequest{}, err
+	}
+
+	return Decode(m)
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		request PurchaseRequest
+	}{
+		{
+			name: "single item",
+			request: PurchaseRequest{
+				Items:   []Item{{PolicyID: testPolicyID, AssetID: testAssetID, Quantity: 1}},
+				Address: testAddress,
+			},
+		},
+		{
+			name: "basket with quoted prices",
+			request: PurchaseRequest{
+				Items: []Item{
+					{PolicyID: testPolicyID, AssetID: testAssetID, Quantity: 3, QuotedPrice: 2500000},
+					{PolicyID: testPolicy2, AssetID: testAsset2, Quantity: 18446744073709551615},
+				},
+				Address:       testAddress,
+				RefundAddress: testRefundAddress,
+			},
+		},
+		{
+			name: "address of exactly one string",
+			request: PurchaseRequest{
+				Items:   []Item{{PolicyID: testPolicyID, AssetID: testAssetID, Quantity: 2}},
+				Address: strings.Repeat("a", maxChunkLength),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.request
+			want.Version = CurrentVersion
+
+			m := Encode(tt.request)
+
+			for label, value := range m {
+				for i, chunk := range value.List {
+					if len(chunk.String) > maxChunkLength {
+						t.Fatalf("label %s chunk %d has %d bytes, more than %d", label, i, len(chunk.String), maxChunkLength)
+					}
+				}
+			}
+
+			got, err := Decode(m)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("Decode = %+v, want %+v", got, want)
+			}
+
+			// as cardano-wallet returns it
+			b, err := json.Marshal(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err = decodeJSON(t, string(b))
+			if err != nil {
+				t.Fatalf("Decode of JSON %s: %v", b, err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("Decode of JSON = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeVersions(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want PurchaseRequest
+	}{
+		{
+			name: "v1 split addresses",
+			json: `{
+				"1002": {"string": "` + testPolicyID + `"},
+				"1003": {"string": "` + testAssetID + `"},
+				"1004": {"int": 2},
+				"1010": {"string": "` + testAddress[:64] + `"},
+				"1011": {"string": "` + testAddress[64:] + `"},
+				"1020": {"string": "` + testRefundAddress[:40] + `"},
+				"1021": {"string": "` + testRefundAddress[40:] + `"}
+			}`,
+			want: PurchaseRequest{
+				Version:       Version1,
+				Items:         []Item{{PolicyID: testPolicyID, AssetID: testAssetID, Quantity: 2}},
+				Address:       testAddress,
+				RefundAddress: testRefundAddress,
+			},
+		},
+		{
+			name: "v2 chunked address and quoted price",
+			json: `{
+				"1000": {"int": 2},
+				"1002": {"string": "` + testPolicyID + `"},
+				"1003": {"string": "` + testAssetID + `"},
+				"1004": {"int": 1},
+				"1005": {"int": 1500000},
+				"1010": {"list": [{"string": "` + testAddress[:64] + `"}, {"string": "` + testAddress[64:] + `"}]}
+			}`,
+			want: PurchaseRequest{
+				Version: Version2,
+				Items:   []Item{{PolicyID: testPolicyID, AssetID: testAssetID, Quantity: 1, QuotedPrice: 1500000}},
+				Address: testAddress,
+			},
+		},
+		{
+			name: "v3 without a basket",
+			json: `{
+				"1000": {"int": 3},
+				"1002": {"string": "` + testPolicyID + `"},
+				"1003": {"string": "` + testAssetID + `"},
+				"1004": {"int": 4},
+				"1010": {"string": "` + testRefundAddress + `"}
+			}`,
+			want: PurchaseRequest{
+				Version: Version3,
+				Items:   []Item{{PolicyID: testPolicyID, AssetID: testAssetID, Quantity: 4}},
+				Address: testRefundAddress,
+			},
+		},
+		{
+			name: "unknown labels are ignored",
+			json: `{
+				"674": {"map": [{"k": {"string": "msg"}, "v": {"list": [{"string": "hello"}]}}]},
+				"1000": {"int": 3},
+				"1001": {"bytes": "00ff"},
+				"1006": {"list": [{"list": [{"string": "` + testPolicyID + `"}, {"string": "` + testAssetID + `"}, {"int": 1}]}]},
+				"1010": {"string": "` + testRefundAddress + `"},
+				"1099": {"int": 7}
+			}`,
+			want: PurchaseRequest{
+				Version: Version3,
+				Items:   []Item{{PolicyID: testPolicyID, AssetID: testAssetID, Quantity: 1}},
+				Address: testRefundAddress,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeJSON(t, tt.json)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Decode = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	item := `{"list": [{"string": "` + testPolicyID + `"}, {"string": "` + testAssetID + `"}, {"int": 1}]}`
+	address := `"1010": {"string": "` + testRefundAddress + `"}`
+
+	tests := []struct {
+		name string
+		json string
+
+		// fields are the fields reported invalid, none when the metadata
+		// doesn't even unmarshal
+		fields []string
+	}{
+		{
+			name:   "unsupported version",
+			json:   `{"1000": {"int": 4}, "1006": {"list": [` + item + `]}, ` + address + `}`,
+			fields: []string{"version"},
+		},
+		{
+			name:   "version as a string",
+			json:   `{"1000": {"string": "3"}, "1006": {"list": [` + item + `]}, ` + address + `}`,
+			fields: []string{"version"},
+		},
+		{
+			name:   "zero quantity",
+			json:   `{"1000": {"int": 3}, "1006": {"list": [{"list": [{"string": "` + testPolicyID + `"}, {"string": "` + testAssetID + `"}, {"int": 0}]}]}, ` + address + `}`,
+			fields: []string{"items[0].quantity"},
+		},
+		{
+			name:   "missing quantity",
+			json:   `{"1000": {"int": 2}, "1002": {"string": "` + testPolicyID + `"}, "1003": {"string": "` + testAssetID + `"}, ` + address + `}`,
+			fields: []string{"quantity"},
+		},
+		{
+			name: "negative quantity",
+			json: `{"1000": {"int": 2}, "1002": {"string": "` + testPolicyID + `"}, "1003": {"string": "` + testAssetID + `"}, "1004": {"int": -1}, ` + address + `}`,
+		},
+		{
+			name:   "oversize address chunk",
+			json:   `{"1000": {"int": 3}, "1006": {"list": [` + item + `]}, "1010": {"list": [{"string": "` + testAddress[:65] + `"}]}}`,
+			fields: []string{"address"},
+		},
+		{
+			name:   "empty address chunk",
+			json:   `{"1000": {"int": 3}, "1006": {"list": [` + item + `]}, "1010": {"list": [{"string": "` + testAddress[:64] + `"}, {"string": ""}]}}`,
+			fields: []string{"address"},
+		},
+		{
+			name:   "oversize refund address chunk",
+			json:   `{"1000": {"int": 3}, "1006": {"list": [` + item + `]}, ` + address + `, "1020": {"list": [{"string": "` + testAddress[:65] + `"}]}}`,
+			fields: []string{"refund_address"},
+		},
+		{
+			name:   "oversize asset name",
+			json:   `{"1000": {"int": 3}, "1006": {"list": [{"list": [{"string": "` + testPolicyID + `"}, {"string": "` + strings.Repeat("ab", 33) + `"}, {"int": 1}]}]}, ` + address + `}`,
+			fields: []string{"items[0].asset_id"},
+		},
+		{
+			name:   "oversize policy ID",
+			json:   `{"1000": {"int": 2}, "1002": {"string": "` + testPolicyID + `00"}, "1003": {"string": "` + testAssetID + `"}, "1004": {"int": 1}, ` + address + `}`,
+			fields: []string{"policy_id"},
+		},
+		{
+			name:   "policy ID not hex",
+			json:   `{"1000": {"int": 2}, "1002": {"string": "` + strings.Repeat("z", 56) + `"}, "1003": {"string": "` + testAssetID + `"}, "1004": {"int": 1}, ` + address + `}`,
+			fields: []string{"policy_id"},
+		},
+		{
+			name:   "missing address",
+			json:   `{"1000": {"int": 3}, "1006": {"list": [` + item + `]}}`,
+			fields: []string{"address"},
+		},
+		{
+			name:   "duplicate basket item",
+			json:   `{"1000": {"int": 3}, "1006": {"list": [` + item + `, ` + item + `]}, ` + address + `}`,
+			fields: []string{"items[1]"},
+		},
+		{
+			name:   "empty basket",
+			json:   `{"1000": {"int": 3}, "1006": {"list": []}, ` + address + `}`,
+			fields: []string{"items"},
+		},
+		{
+			name:   "basket as a map",
+			json:   `{"1000": {"int": 3}, "1006": {"map": [{"k": {"string": "` + testPolicyID + `"}, "v": {"int": 1}}]}, ` + address + `}`,
+			fields: []string{"items"},
+		},
+		{
+			name:   "basket item as a map",
+			json:   `{"1000": {"int": 3}, "1006": {"list": [{"map": [{"k": {"string": "policy_id"}, "v": {"string": "` + testPolicyID + `"}}]}]}, ` + address + `}`,
+			fields: []string{"items[0]"},
+		},
+		{
+			name:   "basket item too long",
+			json:   `{"1000": {"int": 3}, "1006": {"list": [{"list": [{"string": "` + testPolicyID + `"}, {"string": "` + testAssetID + `"}, {"int": 1}, {"int": 1}, {"int": 1}]}]}, ` + address + `}`,
+			fields: []string{"items[0]"},
+		},
+		{
+			name:   "basket fields as maps",
+			json:   `{"1000": {"int": 3}, "1006": {"list": [{"list": [{"map": []}, {"map": []}, {"map": []}]}]}, ` + address + `}`,
+			fields: []string{"items[0].policy_id", "items[0].asset_id", "items[0].quantity"},
+		},
+		{
+			name:   "address as a map",
+			json:   `{"1000": {"int": 3}, "1006": {"list": [` + item + `]}, "1010": {"map": [{"k": {"string": "address"}, "v": {"string": "` + testRefundAddress + `"}}]}}`,
+			fields: []string{"address"},
+		},
+		{
+			name: "malformed map entry",
+			json: `{"1000": {"int": 3}, "1006": {"map": [{"k": "policy"}]}, ` + address + `}`,
+		},
+		{
+			name: "not a metadata map",
+			json: `[{"1000": {"int": 3}}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeJSON(t, tt.json)
+			if err == nil {
+				t.Fatal("Decode accepted invalid metadata")
+			}
+
+			if len(tt.fields) == 0 {
+				if errors.Is(err, ErrInvalid) {
+					t.Fatalf("err = %v, want the metadata rejected before decoding", err)
+				}
+				return
+			}
+
+			var validationErr ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("err = %v, want a ValidationError", err)
+			}
+
+			var fields []string
+			for _, fieldErr := range validationErr {
+				fields = append(fields, fieldErr.Field)
+			}
+
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Fatalf("invalid fields = %v, want %v (%v)", fields, tt.fields, err)
+			}
+		})
+	}
+}
+
+func TestRefundAddressOfBrokenMetadata(t *testing.T) {
+	m := cwalletapi.Metadata{
+		LabelVersion:       {Int: Version2},
+		LabelQuantity:      {Int: 0},
+		LabelRefundAddress: {List: chunks(testAddress)},
+	}
+
+	if _, err := Decode(m); err == nil {
+		t.Fatal("Decode accepted metadata without an asset")
+	}
+
+	if got := RefundAddress(m); got != testAddress {
+		t.Fatalf("RefundAddress = %q, want %q", got, testAddress)
+	}
+}
+
+func TestEncodeRefund(t *testing.T) {
+	const txID = "a1b2c3"
+
+	m := EncodeRefund(txID)
+
+	if got := m[LabelRefundOf].String; got != txID {
+		t.Fatalf("label %s = %q, want %q", LabelRefundOf, got, txID)
+	}
+
+	// a refund isn't a purchase
+	if _, err := Decode(m); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Decode of refund metadata: err = %v, want ErrInvalid", err)
+	}
+}
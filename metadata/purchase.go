@@ -0,0 +1,317 @@
+// Package metadata encodes and decodes the transaction metadata of purchase
+// requests.
+//
+// A purchase is a payment to a sale wallet whose metadata names the assets,
+// the number of lots of each and the address the tokens are paid out to:
+//
+//	1000  schema version (int, missing in v1)
+//	1002  policy ID (hex string)
+//	1003  asset name (hex string)
+//	1004  lots (int)
+//	1005  quoted lovelace price per lot (int, optional)
+//	1006  basket (v3): list of [policy ID, asset name, lots, quoted price]
+//	1010  payout address
+//	1020  refund address (optional)
+//
+// In v1 the addresses are split in two strings under 1010+1011 and
+// 1020+1021. From v2 on they are a list of strings of up to 64 bytes each
+// under a single label, so addresses of any length fit. From v3 on a purchase
+// may list several assets in the basket; the quoted price of a basket item is
+// optional. Without a basket the single asset of 1002-1005 is bought.
+package metadata
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+)
+
+const (
+	LabelVersion           = "1000"
+	LabelPolicyID          = "1002"
+	LabelAssetID           = "1003"
+	LabelQuantity          = "1004"
+	LabelQuotedPrice       = "1005"
+	LabelBasket            = "1006"
+	LabelAddress           = "1010"
+	LabelAddressTail       = "1011" // v1 only
+	LabelRefundAddress     = "1020"
+	LabelRefundAddressTail = "1021" // v1 only
+
+	// LabelRefundOf links a refund transaction to the purchase it pays back.
+	LabelRefundOf = "1030"
+)
+
+const (
+	Version1 = 1
+	Version2 = 2
+	Version3 = 3
+
+	CurrentVersion = Version3
+)
+
+// maxChunkLength is the longest string allowed in transaction metadata.
+const maxChunkLength = 64
+
+// PurchaseRequest is the purchase described by a buyer's transaction
+// metadata.
+type PurchaseRequest struct {
+	Version uint64
+
+	Items []Item
+
+	Address       string
+	RefundAddress string
+}
+
+// Item is one asset of a purchase.
+type Item struct {
+	PolicyID string
+	AssetID  string
+	Quantity uint64
+
+	// QuotedPrice is the lovelace price per lot the buyer was quoted, or zero.
+	QuotedPrice uint64
+}
+
+// Encode returns the metadata of the purchase request in the current schema
+// version.
+func Encode(r PurchaseRequest) cwalletapi.Metadata {
+	basket := make([]cwalletapi.MetadataValue, 0, len(r.Items))
+	for _, item := range r.Items {
+		value := []cwalletapi.MetadataValue{
+			{String: item.PolicyID},
+			{String: item.AssetID},
+			{Int: item.Quantity},
+		}
+
+		if item.QuotedPrice > 0 {
+			value = append(value, cwalletapi.MetadataValue{Int: item.QuotedPrice})
+		}
+
+		basket = append(basket, cwalletapi.MetadataValue{List: value})
+	}
+
+	m := cwalletapi.Metadata{
+		LabelVersion: {Int: CurrentVersion},
+		LabelBasket:  {List: basket},
+		LabelAddress: {List: chunks(r.Address)},
+	}
+
+	if r.RefundAddress != "" {
+		m[LabelRefundAddress] = cwalletapi.MetadataValue{List: chunks(r.RefundAddress)}
+	}
+
+	return m
+}
+
+// EncodeRefund returns the metadata of a refund of the purchase transaction.
+func EncodeRefund(purchaseTxID string) cwalletapi.Metadata {
+	return cwalletapi.Metadata{
+		LabelVersion:  {Int: CurrentVersion},
+		LabelRefundOf: {String: purchaseTxID},
+	}
+}
+
+// Decode decodes and validates purchase request metadata. Metadata without a
+// version label is read as v1. All invalid fields are reported together in a
+// ValidationError.
+func Decode(m cwalletapi.Metadata) (r PurchaseRequest, err error) {
+	var errs ValidationError
+
+	r.Version = Version1
+	if v, ok := m[LabelVersion]; ok {
+		r.Version = v.Int
+	}
+
+	if r.Version < Version1 || r.Version > Version3 {
+		errs.add(LabelVersion, "version", fmt.Sprintf("unsupported version %d", r.Version))
+		return r, errs
+	}
+
+	if basket, ok := m[LabelBasket]; ok && r.Version >= Version3 {
+		r.Items = decodeBasket(basket, &errs)
+	} else {
+		r.Items = []Item{decodeSingleItem(m, &errs)}
+	}
+
+	r.Address, err = decodeAddress(m, r.Version, LabelAddress, LabelAddressTail)
+	switch {
+	case err != nil:
+		errs.add(LabelAddress, "address", err.Error())
+	case r.Address == "":
+		errs.add(LabelAddress, "address", "is required")
+	}
+
+	r.RefundAddress, err = decodeAddress(m, r.Version, LabelRefundAddress, LabelRefundAddressTail)
+	if err != nil {
+		errs.add(LabelRefundAddress, "refund_address", err.Error())
+	}
+
+	if len(errs) > 0 {
+		return r, errs
+	}
+
+	return r, nil
+}
+
+// RefundAddress returns the refund address of purchase metadata without
+// validating the rest of it, so purchases with broken metadata can still be
+// refunded.
+func RefundAddress(m cwalletapi.Metadata) string {
+	version := uint64(Version1)
+	if v, ok := m[LabelVersion]; ok {
+		version = v.Int
+	}
+
+	address, err := decodeAddress(m, version, LabelRefundAddress, LabelRefundAddressTail)
+	if err != nil {
+		return ""
+	}
+
+	return address
+}
+
+// ----------------------------------------------------------------------
+
+func decodeSingleItem(m cwalletapi.Metadata, errs *ValidationError) Item {
+	item := Item{
+		PolicyID:    m[LabelPolicyID].String,
+		AssetID:     m[LabelAssetID].String,
+		Quantity:    m[LabelQuantity].Int,
+		QuotedPrice: m[LabelQuotedPrice].Int,
+	}
+
+	if message := checkPolicyID(item.PolicyID); message != "" {
+		errs.add(LabelPolicyID, "policy_id", message)
+	}
+
+	if message := checkAssetID(item.AssetID); message != "" {
+		errs.add(LabelAssetID, "asset_id", message)
+	}
+
+	if item.Quantity == 0 {
+		errs.add(LabelQuantity, "quantity", "must be greater than 0")
+	}
+
+	return item
+}
+
+func decodeBasket(basket cwalletapi.MetadataValue, errs *ValidationError) (items []Item) {
+	if len(basket.List) == 0 {
+		errs.add(LabelBasket, "items", "must list at least one item")
+		return items
+	}
+
+	seen := make(map[string]bool)
+
+	for i, value := range basket.List {
+		field := fmt.Sprintf("items[%d]", i)
+
+		if len(value.List) < 3 || len(value.List) > 4 {
+			errs.add(LabelBasket, field, "must be a list of policy ID, asset name, lots and an optional quoted price")
+			continue
+		}
+
+		item := Item{
+			PolicyID: value.List[0].String,
+			AssetID:  value.List[1].String,
+			Quantity: value.List[2].Int,
+		}
+
+		if len(value.List) == 4 {
+			item.QuotedPrice = value.List[3].Int
+		}
+
+		if message := checkPolicyID(item.PolicyID); message != "" {
+			errs.add(LabelBasket, field+".policy_id", message)
+		}
+
+		if message := checkAssetID(item.AssetID); message != "" {
+			errs.add(LabelBasket, field+".asset_id", message)
+		}
+
+		if item.Quantity == 0 {
+			errs.add(LabelBasket, field+".quantity", "must be greater than 0")
+		}
+
+		tokenID := item.PolicyID + "." + item.AssetID
+		if seen[tokenID] {
+			errs.add(LabelBasket, field, "lists "+tokenID+" more than once")
+		}
+
+		seen[tokenID] = true
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func checkPolicyID(policyID string) string {
+	switch {
+	case policyID == "":
+		return "is required"
+	case len(policyID) != 56 || !isHex(policyID):
+		return "must be 56 hex characters"
+	}
+
+	return ""
+}
+
+func checkAssetID(assetID string) string {
+	switch {
+	case assetID == "":
+		return "is required"
+	case len(assetID) > 64 || len(assetID)%2 != 0 || !isHex(assetID):
+		return "must be hex of at most 64 characters"
+	}
+
+	return ""
+}
+
+func decodeAddress(m cwalletapi.Metadata, version uint64, label, tailLabel string) (string, error) {
+	value := m[label]
+
+	if version == Version1 && len(value.List) == 0 {
+		return value.String + m[tailLabel].String, nil
+	}
+
+	if value.String != "" {
+		return value.String, nil
+	}
+
+	var address strings.Builder
+	for i, chunk := range value.List {
+		if chunk.String == "" {
+			return "", fmt.Errorf("chunk %d must be a non-empty string", i)
+		}
+
+		if len(chunk.String) > maxChunkLength {
+			return "", fmt.Errorf("chunk %d is longer than %d bytes", i, maxChunkLength)
+		}
+
+		address.WriteString(chunk.String)
+	}
+
+	return address.String(), nil
+}
+
+func chunks(s string) (list []cwalletapi.MetadataValue) {
+	for len(s) > maxChunkLength {
+		list = append(list, cwalletapi.MetadataValue{String: s[:maxChunkLength]})
+		s = s[maxChunkLength:]
+	}
+
+	if s != "" {
+		list = append(list, cwalletapi.MetadataValue{String: s})
+	}
+
+	return list
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,157 @@
+package price
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+)
+
+// Cache serves prices from a Source, refetching them at most once per
+// refresh interval. When the source fails, the last price is served until it
+// is older than maxAge, after which the asset reports ErrStale and sales halt.
+//
+// The cache also remembers every price it served during the last quoteTTL, so
+// a purchase can be validated against the price the buyer was quoted rather
+// than the one current when the payout is built.
+type Cache struct {
+	source Source
+
+	refresh  time.Duration
+	maxAge   time.Duration
+	quoteTTL time.Duration
+
+	mx      *sync.Mutex
+	quotes  map[string][]Quote // price history per token, newest last
+	fetches map[string]*fetch  // refetches in flight per token
+}
+
+// fetch is a refetch of a token's price, shared by the callers that ask for
+// the price while it is in flight. done is closed once quote and err are set.
+type fetch struct {
+	done  chan struct{}
+	quote Quote
+	err   error
+}
+
+func NewCache(source Source, refresh, maxAge, quoteTTL time.Duration) *Cache {
+	return &Cache{
+		source:   source,
+		refresh:  refresh,
+		maxAge:   maxAge,
+		quoteTTL: quoteTTL,
+		mx:       &sync.Mutex{},
+		quotes:   make(map[string][]Quote),
+		fetches:  make(map[string]*fetch),
+	}
+}
+
+// Price returns the current price snapshot of the asset.
+func (c *Cache) Price(asset config.Asset) (q Quote, err error) {
+	return c.price(asset)
+}
+
+// Quoted returns the snapshot of the asset with the given lovelace price, if
+// that price was served within the quote TTL. A zero lovelace returns the
+// current price.
+func (c *Cache) Quoted(asset config.Asset, lovelace uint64) (q Quote, err error) {
+	current, err := c.price(asset)
+	if err != nil {
+		return q, err
+	}
+
+	if lovelace == 0 || lovelace == current.Lovelace {
+		return current, nil
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	now := time.Now().UTC()
+	for _, quote := range c.quotes[TokenID(asset)] {
+		if quote.Lovelace == lovelace && now.Sub(quote.LastSeenAt) <= c.quoteTTL {
+			return quote, nil
+		}
+	}
+
+	return q, ErrQuoteExpired
+}
+
+// price returns the last price of the asset while it is fresh, and refetches
+// it otherwise. The source is asked without holding the lock, once per token
+// however many callers wait for the price.
+func (c *Cache) price(asset config.Asset) (q Quote, err error) {
+	tokenID := TokenID(asset)
+
+	c.mx.Lock()
+	if history := c.quotes[tokenID]; len(history) > 0 {
+		q = history[len(history)-1]
+		if time.Now().UTC().Sub(q.LastSeenAt) < c.refresh {
+			c.mx.Unlock()
+			return q, nil
+		}
+	}
+
+	f, inFlight := c.fetches[tokenID]
+	if !inFlight {
+		f = &fetch{done: make(chan struct{}), err: fmt.Errorf("%w: price fetch failed", ErrStale)}
+		c.fetches[tokenID] = f
+	}
+	c.mx.Unlock()
+
+	if inFlight {
+		<-f.done
+		return f.quote, f.err
+	}
+
+	defer func() {
+		c.mx.Lock()
+		delete(c.fetches, tokenID)
+		c.mx.Unlock()
+
+		close(f.done)
+	}()
+
+	fetched, fetchErr := c.source.Price(asset)
+
+	c.mx.Lock()
+	f.quote, f.err = c.update(tokenID, fetched, fetchErr)
+	c.mx.Unlock()
+
+	return f.quote, f.err
+}
+
+// update records the price fetched for the token, or falls back on the last
+// one while it is younger than maxAge when the fetch failed.
+func (c *Cache) update(tokenID string, fetched Quote, err error) (q Quote, _ error) {
+	history := c.quotes[tokenID]
+	now := time.Now().UTC()
+
+	if len(history) > 0 {
+		q = history[len(history)-1]
+	}
+
+	if err != nil {
+		if len(history) > 0 && now.Sub(q.LastSeenAt) < c.maxAge {
+			return q, nil
+		}
+
+		return q, fmt.Errorf("%w: %s", ErrStale, err)
+	}
+
+	if len(history) > 0 && q.Lovelace == fetched.Lovelace {
+		history[len(history)-1].LastSeenAt = fetched.LastSeenAt
+	} else {
+		history = append(history, fetched)
+	}
+
+	// forget prices that can no longer be quoted
+	for len(history) > 1 && now.Sub(history[0].LastSeenAt) > c.quoteTTL {
+		history = history[1:]
+	}
+
+	c.quotes[tokenID] = history
+
+	return history[len(history)-1], nil
+}
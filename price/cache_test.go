@@ -0,0 +1,76 @@
+package price
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+)
+
+// blockingSource returns a fixed price once release is closed, counting the
+// fetches of each token.
+type blockingSource struct {
+	release chan struct{}
+	fetches sync.Map // token ID to *atomic.Int32
+}
+
+func (s *blockingSource) Price(asset config.Asset) (Quote, error) {
+	n, _ := s.fetches.LoadOrStore(TokenID(asset), new(atomic.Int32))
+	n.(*atomic.Int32).Add(1)
+
+	<-s.release
+
+	now := time.Now().UTC()
+
+	return Quote{Lovelace: 1000000, FetchedAt: now, LastSeenAt: now}, nil
+}
+
+func (s *blockingSource) count(asset config.Asset) int32 {
+	n, ok := s.fetches.Load(TokenID(asset))
+	if !ok {
+		return 0
+	}
+
+	return n.(*atomic.Int32).Load()
+}
+
+func TestCacheSharesFetches(t *testing.T) {
+	source := &blockingSource{release: make(chan struct{})}
+	cache := NewCache(source, time.Minute, time.Hour, time.Hour)
+
+	asset := config.Asset{PolicyID: "p", AssetID: "a"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if q, err := cache.Price(asset); err != nil || q.Lovelace != 1000000 {
+				t.Errorf("Price = %d, %v, want 1000000 lovelace", q.Lovelace, err)
+			}
+		}()
+	}
+
+	// wait for the fetch to start
+	for source.count(asset) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the cache isn't locked while the source is asked
+	other := config.Asset{PolicyID: "p", AssetID: "b"}
+	go func() { _, _ = cache.Price(other) }()
+
+	for source.count(other) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(source.release)
+	wg.Wait()
+
+	if n := source.count(asset); n != 1 {
+		t.Fatalf("the source was asked %d times, want once", n)
+	}
+}
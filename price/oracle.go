@@ -0,0 +1,118 @@
+package price
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+)
+
+const lovelacePerAda = 1000000
+
+// OracleSource prices an asset from a USD price oracle. tokenURL returns the
+// USD price of one lot of the asset, with "{token}" replaced by
+// "policyID.assetID"; adaUSDURL returns the USD price of one ADA. Both must
+// answer with a JSON object holding the price in field.
+type OracleSource struct {
+	tokenURL  string
+	adaUSDURL string
+	field     string
+
+	client *http.Client
+}
+
+func NewOracleSource(tokenURL, adaUSDURL, field string) *OracleSource {
+	if field == "" {
+		field = "usd"
+	}
+
+	return &OracleSource{
+		tokenURL:  tokenURL,
+		adaUSDURL: adaUSDURL,
+		field:     field,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (o *OracleSource) Price(asset config.Asset) (q Quote, err error) {
+	tokenUSD, err := o.fetch(strings.ReplaceAll(o.tokenURL, "{token}", TokenID(asset)))
+	if err != nil {
+		return q, err
+	}
+
+	adaUSD, err := o.fetch(o.adaUSDURL)
+	if err != nil {
+		return q, err
+	}
+
+	lovelace, err := lovelacePrice(tokenUSD, adaUSD)
+	if err != nil {
+		return q, err
+	}
+
+	now := time.Now().UTC()
+
+	return Quote{
+		Lovelace:   lovelace,
+		FetchedAt:  now,
+		LastSeenAt: now,
+	}, nil
+}
+
+// lovelacePrice converts the USD prices of a lot and of one ADA to the
+// lovelace price of the lot, rounded up. Prices that aren't finite and
+// positive, or whose lovelace price doesn't fit in a uint64, are rejected.
+func lovelacePrice(tokenUSD, adaUSD float64) (uint64, error) {
+	if !(tokenUSD > 0) || math.IsInf(tokenUSD, 0) {
+		return 0, fmt.Errorf("invalid token/USD price: %v", tokenUSD)
+	}
+
+	if !(adaUSD > 0) || math.IsInf(adaUSD, 0) {
+		return 0, fmt.Errorf("invalid ADA/USD price: %v", adaUSD)
+	}
+
+	lovelace := math.Ceil(tokenUSD / adaUSD * lovelacePerAda)
+
+	// float64(math.MaxUint64) rounds up to 2^64, which doesn't fit
+	if math.IsInf(lovelace, 0) || lovelace >= float64(math.MaxUint64) {
+		return 0, fmt.Errorf("token price of %v USD at %v USD/ADA is out of range", tokenUSD, adaUSD)
+	}
+
+	return uint64(lovelace), nil
+}
+
+func (o *OracleSource) fetch(url string) (usd float64, err error) {
+	resp, err := o.client.Get(url)
+	if err != nil {
+		return usd, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return usd, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return usd, fmt.Errorf("price not found: %s - %s", resp.Status, string(b))
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(b, &fields); err != nil {
+		return usd, err
+	}
+
+	value, ok := fields[o.field]
+	if !ok {
+		return usd, fmt.Errorf("price field %q not found", o.field)
+	}
+
+	err = json.Unmarshal(value, &usd)
+
+	return usd, err
+}
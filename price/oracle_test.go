@@ -0,0 +1,105 @@
+package price
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+)
+
+func TestLovelacePrice(t *testing.T) {
+	tests := []struct {
+		name             string
+		tokenUSD, adaUSD float64
+		want             uint64
+		wantErr          bool
+	}{
+		{name: "one ADA", tokenUSD: 0.25, adaUSD: 0.25, want: 1000000},
+		{name: "rounded up", tokenUSD: 1, adaUSD: 3, want: 333334},
+		{name: "below a lovelace", tokenUSD: 1e-12, adaUSD: 1, want: 1},
+		{name: "zero token price", tokenUSD: 0, adaUSD: 0.25, wantErr: true},
+		{name: "negative token price", tokenUSD: -1, adaUSD: 0.25, wantErr: true},
+		{name: "zero ADA price", tokenUSD: 1, adaUSD: 0, wantErr: true},
+		{name: "negative ADA price", tokenUSD: 1, adaUSD: -0.25, wantErr: true},
+		{name: "NaN token price", tokenUSD: math.NaN(), adaUSD: 0.25, wantErr: true},
+		{name: "NaN ADA price", tokenUSD: 1, adaUSD: math.NaN(), wantErr: true},
+		{name: "infinite token price", tokenUSD: math.Inf(1), adaUSD: 0.25, wantErr: true},
+		{name: "infinite ADA price", tokenUSD: 1, adaUSD: math.Inf(1), wantErr: true},
+		{name: "overflows", tokenUSD: 1e300, adaUSD: 1e-300, wantErr: true},
+		{name: "beyond uint64", tokenUSD: 2e13, adaUSD: 1, wantErr: true},
+		{name: "largest fitting", tokenUSD: 1e13, adaUSD: 1, want: 1e19},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lovelacePrice(tt.tokenUSD, tt.adaUSD)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("lovelacePrice(%v, %v) = %d, want an error", tt.tokenUSD, tt.adaUSD, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("lovelacePrice(%v, %v): %v", tt.tokenUSD, tt.adaUSD, err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("lovelacePrice(%v, %v) = %d, want %d", tt.tokenUSD, tt.adaUSD, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOracleSourcePrice(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokenBody string
+		adaBody   string
+		want      uint64
+		wantErr   bool
+	}{
+		{name: "priced", tokenBody: `{"usd": 0.5}`, adaBody: `{"usd": 0.25}`, want: 2000000},
+		{name: "zero token price", tokenBody: `{"usd": 0}`, adaBody: `{"usd": 0.25}`, wantErr: true},
+		{name: "negative ADA price", tokenBody: `{"usd": 0.5}`, adaBody: `{"usd": -0.25}`, wantErr: true},
+		{name: "missing field", tokenBody: `{"eur": 0.5}`, adaBody: `{"usd": 0.25}`, wantErr: true},
+		{name: "not a number", tokenBody: `{"usd": "0.5"}`, adaBody: `{"usd": 0.25}`, wantErr: true},
+		{name: "out of range", tokenBody: `{"usd": 1e300}`, adaBody: `{"usd": 1e-300}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/token/p.a", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.tokenBody)
+			})
+			mux.HandleFunc("/ada", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.adaBody)
+			})
+
+			server := httptest.NewServer(mux)
+			t.Cleanup(server.Close)
+
+			source := NewOracleSource(server.URL+"/token/{token}", server.URL+"/ada", "")
+
+			q, err := source.Price(config.Asset{PolicyID: "p", AssetID: "a"})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Price = %d lovelace, want an error", q.Lovelace)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Price: %v", err)
+			}
+
+			if q.Lovelace != tt.want {
+				t.Fatalf("Price = %d lovelace, want %d", q.Lovelace, tt.want)
+			}
+		})
+	}
+}
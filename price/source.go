@@ -0,0 +1,68 @@
+package price
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+)
+
+var (
+	ErrStale        = errors.New("price is stale")
+	ErrQuoteExpired = errors.New("price quote expired")
+)
+
+// Source provides the current price of one lot of an asset.
+type Source interface {
+	Price(asset config.Asset) (Quote, error)
+}
+
+// Quote is a price snapshot of one lot of an asset in lovelace.
+type Quote struct {
+	Lovelace  uint64    `json:"lovelace"`
+	FetchedAt time.Time `json:"fetched_at"`
+
+	// LastSeenAt is when the source last returned this same price.
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// TokenID returns the asset key used by price sources: "policyID.assetID".
+func TokenID(asset config.Asset) string {
+	return asset.PolicyID + "." + asset.AssetID
+}
+
+// Reloader is a Source that has to be refreshed periodically.
+type Reloader interface {
+	Reload(ctx context.Context) error
+}
+
+// NewSource builds the source selected in the price config.
+func NewSource(conf config.PriceConfig) (Source, error) {
+	switch conf.Source {
+	case "", config.PriceSourceStatic:
+		return StaticSource{}, nil
+	case config.PriceSourceFile:
+		return NewFileSource(conf.File)
+	case config.PriceSourceOracle:
+		return NewOracleSource(conf.OracleTokenURL, conf.OracleAdaUSDURL, conf.OracleField), nil
+	}
+
+	return nil, fmt.Errorf("unknown price source: %s", conf.Source)
+}
+
+// ----------------------------------------------------------------------
+
+// StaticSource returns the lovelace price from the asset config.
+type StaticSource struct{}
+
+func (StaticSource) Price(asset config.Asset) (Quote, error) {
+	now := time.Now().UTC()
+
+	return Quote{
+		Lovelace:   asset.PriceLovelace,
+		FetchedAt:  now,
+		LastSeenAt: now,
+	}, nil
+}
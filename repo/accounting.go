@@ -0,0 +1,315 @@
+package repo
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Report periods.
+const (
+	ReportByDay   = "day"
+	ReportByEpoch = "epoch"
+	ReportByRange = "range"
+)
+
+// ReportRow totals the sales of an asset by a wallet over a period. Lovelace
+// received without a sold asset, like failed purchases, is reported with an
+// empty policy and asset ID.
+type ReportRow struct {
+	Period   string `json:"period"`
+	WalletID string `json:"wallet_id"`
+	PolicyID string `json:"policy_id"`
+	AssetID  string `json:"asset_id"`
+
+	Purchases  uint64 `json:"purchases"`
+	TokensSold uint64 `json:"tokens_sold"`
+
+	// Received is the gross lovelace paid by buyers. NetworkFees are the fees
+	// of payouts and refunds, Deposits the lovelace sent with the tokens,
+	// ProcessingFees the fees retained and Refunds the lovelace paid back.
+	Received       uint64 `json:"received"`
+	NetworkFees    uint64 `json:"network_fees"`
+	Deposits       uint64 `json:"deposits"`
+	ProcessingFees uint64 `json:"processing_fees"`
+	Refunds        uint64 `json:"refunds"`
+}
+
+type reportKey struct {
+	period, walletID, policyID, assetID string
+}
+
+type report struct {
+	groupBy  string
+	from, to time.Time
+	rows     map[reportKey]*ReportRow
+}
+
+// GetReport aggregates purchases and refunds created in [from, to) by
+// groupBy. A zero from or to leaves the range open on that side.
+func (t *TransactionRepo) GetReport(groupBy string, from, to time.Time) (rows []ReportRow, err error) {
+	switch groupBy {
+	case ReportByDay, ReportByEpoch, ReportByRange:
+	default:
+		return nil, fmt.Errorf("invalid report period %q", groupBy)
+	}
+
+	r := report{
+		groupBy: groupBy,
+		from:    from,
+		to:      to,
+		rows:    make(map[reportKey]*ReportRow),
+	}
+
+	purchases := t.purchases.GetPurchases()
+	purchasesByTx := make(map[string]Purchase, len(purchases))
+
+	for _, purchase := range purchases {
+		purchasesByTx[purchase.TxID] = purchase
+
+		if r.contains(purchase.CreatedAt) {
+			r.addPurchase(purchase)
+		}
+	}
+
+	for _, refund := range t.refunds.GetRefunds() {
+		if r.contains(refund.CreatedAt) && refund.Status == RefundStatusSubmitted {
+			r.addRefund(refund, purchasesByTx[refund.PurchaseTxID])
+		}
+	}
+
+	for _, row := range r.rows {
+		rows = append(rows, *row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Period != b.Period {
+			return a.Period < b.Period
+		}
+
+		if a.WalletID != b.WalletID {
+			return a.WalletID < b.WalletID
+		}
+
+		return a.PolicyID+"."+a.AssetID < b.PolicyID+"."+b.AssetID
+	})
+
+	return rows, nil
+}
+
+func (r *report) contains(at time.Time) bool {
+	if !r.from.IsZero() && at.Before(r.from) {
+		return false
+	}
+
+	if !r.to.IsZero() && !at.Before(r.to) {
+		return false
+	}
+
+	return true
+}
+
+func (r *report) period(at time.Time, epoch uint64) string {
+	switch r.groupBy {
+	case ReportByDay:
+		return at.UTC().Format("2006-01-02")
+	case ReportByEpoch:
+		return strconv.FormatUint(epoch, 10)
+	}
+
+	from, to := "", ""
+	if !r.from.IsZero() {
+		from = r.from.UTC().Format(time.RFC3339)
+	}
+
+	if !r.to.IsZero() {
+		to = r.to.UTC().Format(time.RFC3339)
+	}
+
+	return from + "/" + to
+}
+
+func (r *report) row(period, walletID, policyID, assetID string) *ReportRow {
+	key := reportKey{period, walletID, policyID, assetID}
+
+	row, ok := r.rows[key]
+	if !ok {
+		row = &ReportRow{
+			Period:   period,
+			WalletID: walletID,
+			PolicyID: policyID,
+			AssetID:  assetID,
+		}
+		r.rows[key] = row
+	}
+
+	return row
+}
+
+// addPurchase books the items paid out by the purchase. The lovelace
+// received is split by what each item cost, any surplus going to the first
+// item; the network fee of a payout is split among the items it carried.
+// Items of abandoned payouts, refunded or left to the operator, aren't sold:
+// only what they cost is booked, against which addRefund books their refund.
+func (r *report) addPurchase(p Purchase) {
+	period := r.period(p.CreatedAt, p.Epoch)
+
+	paid := make(map[string]PurchasePayout)
+	abandoned := make(map[string]bool)
+	for _, payout := range p.Payouts {
+		if payout.abandoned() {
+			abandoned[payout.WalletID] = true
+			continue
+		}
+
+		paid[payout.WalletID] = payout
+	}
+
+	perWallet := make(map[string]uint64)
+	for _, item := range p.Items {
+		if _, ok := paid[item.WalletID]; ok {
+			perWallet[item.WalletID]++
+		}
+	}
+
+	remaining := p.Received
+	feeBooked := make(map[string]bool)
+	var first *ReportRow
+
+	for _, item := range p.Items {
+		if abandoned[item.WalletID] {
+			cost := item.Price + item.Deposit + item.ProcessingFee
+			if cost > remaining {
+				cost = remaining
+			}
+
+			r.row(period, item.WalletID, item.PolicyID, item.AssetID).Received += cost
+			remaining -= cost
+
+			continue
+		}
+
+		payout, ok := paid[item.WalletID]
+		if !ok {
+			continue
+		}
+
+		row := r.row(period, item.WalletID, item.PolicyID, item.AssetID)
+		row.Purchases++
+		row.TokensSold += item.AssetQuantity
+		row.Deposits += item.Deposit
+		row.ProcessingFees += item.ProcessingFee
+
+		cost := item.Price + item.Deposit + item.ProcessingFee
+		if cost > remaining {
+			cost = remaining
+		}
+
+		row.Received += cost
+		remaining -= cost
+
+		// the first item of each payout also carries the rounding of its fee
+		row.NetworkFees += payout.Fee / perWallet[item.WalletID]
+		if !feeBooked[item.WalletID] {
+			row.NetworkFees += payout.Fee % perWallet[item.WalletID]
+			feeBooked[item.WalletID] = true
+		}
+
+		if first == nil {
+			first = row
+		}
+	}
+
+	if first == nil {
+		// nothing was paid out, the payment is booked to the wallet
+		first = r.row(period, p.WalletID, "", "")
+		first.Purchases++
+	}
+
+	first.Received += remaining
+}
+
+// addRefund books the refund to the row addPurchase booked what it pays back
+// to: a refunded payout to the first item it carried, a refunded purchase to
+// its first item paid out or, with none, to the wallet.
+func (r *report) addRefund(refund Refund, p Purchase) {
+	walletID, policyID, assetID := refund.WalletID, "", ""
+	if p.WalletID != "" {
+		walletID = p.WalletID
+	}
+
+	var payouts []PurchasePayout
+	switch {
+	case refund.Payout == wholePurchase:
+		for _, payout := range p.Payouts {
+			if !payout.abandoned() {
+				payouts = append(payouts, payout)
+			}
+		}
+	case refund.Payout < len(p.Payouts):
+		payouts = p.Payouts[refund.Payout : refund.Payout+1]
+	}
+
+	for _, payout := range payouts {
+		if item, ok := p.firstItem(payout.WalletID); ok {
+			walletID, policyID, assetID = item.WalletID, item.PolicyID, item.AssetID
+			break
+		}
+	}
+
+	row := r.row(r.period(refund.CreatedAt, refund.Epoch), walletID, policyID, assetID)
+	row.Refunds += refund.Amount
+	row.NetworkFees += refund.NetworkFee
+}
+
+// WriteReportCSV writes report rows as CSV with a header line.
+func WriteReportCSV(w io.Writer, rows []ReportRow) error {
+	cw := csv.NewWriter(w)
+
+	header := []string{
+		"period", "wallet_id", "policy_id", "asset_id",
+		"purchases", "tokens_sold", "received", "network_fees",
+		"deposits", "processing_fees", "refunds",
+	}
+
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		record := []string{
+			row.Period, row.WalletID, row.PolicyID, row.AssetID,
+			strconv.FormatUint(row.Purchases, 10),
+			strconv.FormatUint(row.TokensSold, 10),
+			strconv.FormatUint(row.Received, 10),
+			strconv.FormatUint(row.NetworkFees, 10),
+			strconv.FormatUint(row.Deposits, 10),
+			strconv.FormatUint(row.ProcessingFees, 10),
+			strconv.FormatUint(row.Refunds, 10),
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// currentEpoch returns the epoch of the network tip, or zero when the wallet
+// backend can't tell.
+func (t *TransactionRepo) currentEpoch(ctx context.Context) uint64 {
+	networkInfo, err := t.CardanoWalletApi.GetWalletNetworkInformation(ctx)
+	if err != nil {
+		return 0
+	}
+
+	return networkInfo.NetworkTip.EpochNumber
+}
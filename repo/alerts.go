@@ -0,0 +1,129 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/alert"
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+)
+
+type alerts struct {
+	conf     config.AlertConfig
+	notifier *alert.Notifier
+
+	mx           *sync.Mutex
+	syncingSince map[string]time.Time
+}
+
+func newAlerts(conf config.AlertConfig) alerts {
+	return alerts{
+		conf:         conf,
+		notifier:     alert.NewNotifier(conf),
+		mx:           &sync.Mutex{},
+		syncingSince: make(map[string]time.Time),
+	}
+}
+
+// raise notifies the alert while active, and resolves it once its condition
+// clears.
+func (a *alerts) raise(ctx context.Context, active bool, alert alert.Alert) {
+	if !active {
+		a.notifier.Resolve(alert.Key)
+		return
+	}
+
+	a.notifier.Notify(ctx, alert)
+}
+
+// checkWalletAlerts raises the alerts of a wallet after it was polled.
+func (t *TransactionRepo) checkWalletAlerts(ctx context.Context, walletID string) {
+	w, err := t.wallets.GetWallet(walletID)
+	if err != nil {
+		return
+	}
+
+	a := &t.alerts
+	ready := w.state.Status == "ready"
+
+	if a.conf.SyncingSeconds > 0 {
+		a.mx.Lock()
+		since, syncing := a.syncingSince[walletID]
+		switch {
+		case ready:
+			delete(a.syncingSince, walletID)
+		case !syncing:
+			since = time.Now()
+			a.syncingSince[walletID] = since
+		}
+		a.mx.Unlock()
+
+		stuck := time.Since(since)
+		a.raise(ctx, !ready && stuck >= time.Duration(a.conf.SyncingSeconds)*time.Second, alert.Alert{
+			Kind:     alert.KindWalletSyncing,
+			Key:      alert.KindWalletSyncing + "/" + walletID,
+			WalletID: walletID,
+			Message:  fmt.Sprintf("wallet %s has not been ready for %s", walletID, stuck.Round(time.Second)),
+		})
+	}
+
+	// balances are only current while the wallet is ready
+	if !ready {
+		return
+	}
+
+	if a.conf.MinLovelace > 0 {
+		available := w.data.Balance.Available.Quantity
+		a.raise(ctx, available < a.conf.MinLovelace, alert.Alert{
+			Kind:     alert.KindLowBalance,
+			Key:      alert.KindLowBalance + "/" + walletID,
+			WalletID: walletID,
+			Message:  fmt.Sprintf("wallet %s has %d lovelace available, below %d", walletID, available, a.conf.MinLovelace),
+		})
+	}
+
+	if a.conf.MinLots > 0 {
+		for _, asset := range w.Assets {
+			lots := t.lotsForSale(w, asset)
+			a.raise(ctx, lots < a.conf.MinLots, alert.Alert{
+				Kind:     alert.KindLowInventory,
+				Key:      alert.KindLowInventory + "/" + walletID + "/" + asset.PolicyID + "." + asset.AssetID,
+				WalletID: walletID,
+				PolicyID: asset.PolicyID,
+				AssetID:  asset.AssetID,
+				Message:  fmt.Sprintf("wallet %s has %d lots of %s.%s for sale, below %d", walletID, lots, asset.PolicyID, asset.AssetID, a.conf.MinLots),
+			})
+		}
+	}
+}
+
+// lotsForSale returns how many lots of the asset the wallet can still sell
+// as of the last poll.
+func (t *TransactionRepo) lotsForSale(w wallet, asset config.Asset) uint64 {
+	held := asset.Buffer + t.reservations.Reserved(w.ID, asset)
+	stock := w.stock(asset)
+
+	if stock <= held || asset.AssetQuantityWithDecimals == 0 {
+		return 0
+	}
+
+	return (stock - held) / asset.AssetQuantityWithDecimals
+}
+
+// alertPayoutFailed raises an alert for a purchase that couldn't be paid out.
+func (t *TransactionRepo) alertPayoutFailed(ctx context.Context, walletID string, tx cwalletapi.Transaction, err error) {
+	if !t.alerts.conf.PayoutFailures {
+		return
+	}
+
+	t.alerts.notifier.Notify(ctx, alert.Alert{
+		Kind:     alert.KindPayoutFailed,
+		Key:      alert.KindPayoutFailed + "/" + tx.ID,
+		WalletID: walletID,
+		TxID:     tx.ID,
+		Message:  fmt.Sprintf("purchase %s failed: %s", tx.ID, err),
+	})
+}
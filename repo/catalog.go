@@ -0,0 +1,251 @@
+package repo
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bykovme/goconfig"
+
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+)
+
+const catalogFile = "catalog.json"
+
+// CatalogEntry is how a token is presented in the storefront. Tokens without
+// an entry are listed as the wallets hold them.
+type CatalogEntry struct {
+	PolicyID string `json:"policy_id"`
+	AssetID  string `json:"asset_id"`
+
+	// A disabled token isn't served or sold. A hidden one is sold and served
+	// by GetToken, but left out of GetAllTokens.
+	Enabled  bool `json:"enabled"`
+	Hidden   bool `json:"hidden"`
+	Featured bool `json:"featured"`
+
+	// Order sorts GetAllTokens, lowest first.
+	Order int `json:"order"`
+
+	// Name, Ticker, Description and Logo override the token metadata when set.
+	Name        string   `json:"name,omitempty"`
+	Ticker      string   `json:"ticker,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Logo        string   `json:"logo,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+
+	// Wallets are the config keys of the wallets whose holdings back the
+	// token. Empty means every wallet holding it.
+	Wallets []string `json:"wallets,omitempty"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TokenID returns the ID of the token, "policyID.assetID".
+func (e CatalogEntry) TokenID() string {
+	return e.PolicyID + "." + e.AssetID
+}
+
+// holds reports whether the holding of the wallet with the config key backs
+// the token.
+func (e CatalogEntry) holds(walletKey string) bool {
+	if len(e.Wallets) == 0 {
+		return true
+	}
+
+	for _, key := range e.Wallets {
+		if key == walletKey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CatalogToken is a token as the storefront shows it.
+type CatalogToken struct {
+	cwalletapi.WalletAsset
+
+	Featured bool     `json:"featured"`
+	Order    int      `json:"order"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// render applies the entry's overrides to the token.
+func (e CatalogEntry) render(token cwalletapi.WalletAsset) CatalogToken {
+	if e.Name != "" {
+		token.Metadata.Name = e.Name
+	}
+
+	if e.Ticker != "" {
+		token.Metadata.Ticker = e.Ticker
+	}
+
+	if e.Description != "" {
+		token.Metadata.Description = e.Description
+	}
+
+	if e.Logo != "" {
+		token.Metadata.Logo = e.Logo
+	}
+
+	return CatalogToken{
+		WalletAsset: token,
+		Featured:    e.Featured,
+		Order:       e.Order,
+		Tags:        e.Tags,
+	}
+}
+
+type catalog struct {
+	mx      *sync.RWMutex
+	file    string
+	entries map[string]CatalogEntry
+}
+
+type catalogState struct {
+	Entries map[string]CatalogEntry `json:"entries"`
+}
+
+func loadCatalog(stateDir string) catalog {
+	file := filepath.Join(stateDir, catalogFile)
+
+	state := catalogState{}
+
+	if err := goconfig.LoadConfig(file, &state); err != nil {
+		slog.Info("No catalog state found", "file", file)
+	}
+
+	if state.Entries == nil {
+		state.Entries = make(map[string]CatalogEntry)
+	}
+
+	return catalog{
+		mx:      &sync.RWMutex{},
+		file:    file,
+		entries: state.Entries,
+	}
+}
+
+// Get returns the entry of the token, or the default one when it has none.
+func (c *catalog) Get(policyID, assetID string) CatalogEntry {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+
+	entry, ok := c.entries[policyID+"."+assetID]
+	if !ok {
+		return CatalogEntry{
+			PolicyID: policyID,
+			AssetID:  assetID,
+			Enabled:  true,
+		}
+	}
+
+	return entry
+}
+
+func (c *catalog) GetEntries() (entries []CatalogEntry) {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Order != entries[j].Order {
+			return entries[i].Order < entries[j].Order
+		}
+
+		return entries[i].TokenID() < entries[j].TokenID()
+	})
+
+	return entries
+}
+
+func (c *catalog) SetEntry(entry CatalogEntry) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	entry.UpdatedAt = time.Now().UTC()
+	c.entries[entry.TokenID()] = entry
+
+	c.save()
+}
+
+func (c *catalog) DeleteEntry(tokenID string) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if _, ok := c.entries[tokenID]; !ok {
+		return false
+	}
+
+	delete(c.entries, tokenID)
+
+	c.save()
+
+	return true
+}
+
+func (c *catalog) save() {
+	if err := goconfig.SaveConfig(c.file, catalogState{Entries: c.entries}); err != nil {
+		slog.Error("Error saving catalog", "error", err)
+	}
+}
+
+// ----------------------------------------------------------------------
+
+// GetCatalog returns the catalog entries, in display order.
+func (t *TransactionRepo) GetCatalog() []CatalogEntry {
+	return t.catalog.GetEntries()
+}
+
+// SetCatalogEntry adds or replaces the entry of a token, which has to be held
+// by a configured wallet. Its wallets have to be configured wallets holding
+// the token.
+func (t *TransactionRepo) SetCatalogEntry(entry CatalogEntry) (CatalogEntry, error) {
+	holdings := t.wallets.GetWalletsByPolicyID(entry.PolicyID, entry.AssetID)
+	if len(holdings) == 0 {
+		return entry, fmt.Errorf("token %s is not held by any wallet", entry.TokenID())
+	}
+
+	for _, key := range entry.Wallets {
+		found := false
+		for _, h := range holdings {
+			if h.wallet.Key == key {
+				found = true
+			}
+		}
+
+		if !found {
+			return entry, fmt.Errorf("wallet %s doesn't hold token %s", key, entry.TokenID())
+		}
+	}
+
+	t.catalog.SetEntry(entry)
+
+	return t.catalog.Get(entry.PolicyID, entry.AssetID), nil
+}
+
+// DeleteCatalogEntry removes the entry of a token, which is then listed with
+// the defaults again.
+func (t *TransactionRepo) DeleteCatalogEntry(tokenID string) error {
+	if !t.catalog.DeleteEntry(tokenID) {
+		return fmt.Errorf("catalog entry not found")
+	}
+
+	return nil
+}
+
+// checkCatalog checks that the token is enabled for sale.
+func (t *TransactionRepo) checkCatalog(policyID, assetID string) error {
+	if !t.catalog.Get(policyID, assetID).Enabled {
+		return ErrNotForSale
+	}
+
+	return nil
+}
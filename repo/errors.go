@@ -0,0 +1,53 @@
+package repo
+
+import (
+	"errors"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/metadata"
+	"github.com/intellisoftalpin/cardano-wallet-backend/price"
+)
+
+var (
+	ErrInvalidMetadata     = metadata.ErrInvalid
+	ErrWrongAsset          = errors.New("wrong asset")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrInsufficientPayment = errors.New("insufficient payment")
+	ErrSaleNotStarted      = errors.New("sale not started")
+	ErrSaleEnded           = errors.New("sale ended")
+	ErrSoldOut             = errors.New("sold out")
+	ErrBuyerLimit          = errors.New("buyer limit reached")
+	ErrTxLimit             = errors.New("transaction limit exceeded")
+	ErrInvalidQuantity     = errors.New("invalid quantity")
+	ErrInvalidAddress      = errors.New("invalid payout address")
+	ErrPayoutExpired       = errors.New("payout expired")
+	ErrNotForSale          = errors.New("not for sale")
+	ErrPurchaseProcessed   = errors.New("purchase already processed")
+)
+
+// refundableErrors are the purchase failures after which the buyer's ADA has
+// to be paid back, because retrying the same purchase can't succeed.
+var refundableErrors = []error{
+	ErrInvalidMetadata,
+	ErrWrongAsset,
+	ErrInsufficientBalance,
+	ErrInsufficientPayment,
+	ErrSaleNotStarted,
+	ErrSaleEnded,
+	ErrSoldOut,
+	ErrBuyerLimit,
+	ErrTxLimit,
+	ErrInvalidQuantity,
+	ErrInvalidAddress,
+	ErrNotForSale,
+	price.ErrQuoteExpired,
+}
+
+func isRefundable(err error) bool {
+	for _, e := range refundableErrors {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,64 @@
+package repo
+
+import (
+	"github.com/intellisoftalpin/cardano-wallet-backend/events"
+)
+
+// eventHistory is how many wallet events are kept for resuming watchers.
+const eventHistory = 1000
+
+// publishWalletEvents publishes how the wallet changed between two polls.
+func (t *TransactionRepo) publishWalletEvents(before, after wallet) {
+	name := after.data.Name
+	if name == "" {
+		name = before.data.Name
+	}
+
+	if before.state.Status != after.state.Status {
+		t.events.Publish(events.Event{
+			Type:           events.TypeWalletStatus,
+			WalletID:       after.ID,
+			WalletName:     name,
+			PreviousStatus: before.state.Status,
+			Status:         after.state.Status,
+		})
+	}
+
+	// balances are only current while the wallet is ready
+	if after.state.Status != "ready" {
+		return
+	}
+
+	previous, current := before.data.Balance.Available.Quantity, after.data.Balance.Available.Quantity
+	if previous != current {
+		t.events.Publish(events.Event{
+			Type:             events.TypeBalanceChanged,
+			WalletID:         after.ID,
+			WalletName:       name,
+			PreviousLovelace: previous,
+			Lovelace:         current,
+		})
+	}
+
+	for _, asset := range after.Assets {
+		lot := asset.Buffer + asset.AssetQuantityWithDecimals
+
+		// depleted once it can't sell another lot, not on every poll after
+		if before.stock(asset) >= lot && after.stock(asset) < lot {
+			t.events.Publish(events.Event{
+				Type:       events.TypeAssetDepleted,
+				WalletID:   after.ID,
+				WalletName: name,
+				PolicyID:   asset.PolicyID,
+				AssetID:    asset.AssetID,
+				Quantity:   after.stock(asset),
+			})
+		}
+	}
+}
+
+// WatchEvents subscribes to wallet events after the sequence after of the
+// epoch, see events.Bus.Subscribe.
+func (t *TransactionRepo) WatchEvents(epoch string, after uint64) (backlog []events.Event, ch <-chan events.Event, cancel func(), err error) {
+	return t.events.Subscribe(epoch, after)
+}
@@ -2,7 +2,9 @@ package repo
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/intellisoftalpin/cardano-wallet-backend/config"
 	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
@@ -16,20 +18,36 @@ type wallets struct {
 type wallet struct {
 	config.WalletConfig
 	state cwalletapi.WalletState
+
+	// data is the wallet as of the last successful poll, at polledAt.
+	data     cwalletapi.WalletResponse
+	polledAt time.Time
+}
+
+// stock returns the available quantity of the asset in the wallet as of the
+// last poll.
+func (w wallet) stock(asset config.Asset) uint64 {
+	for _, a := range w.data.Assets.Available {
+		if a.PolicyID == asset.PolicyID && a.AssetName == asset.AssetID {
+			return a.Quantity
+		}
+	}
+
+	return 0
 }
 
+// GetWallets returns a copy of the wallets by ID; wallets are added while
+// the server runs.
 func (w *wallets) GetWallets() (wallets map[string]wallet) {
 	w.mx.RLock()
 	defer w.mx.RUnlock()
 
-	return w.wallets
-}
-
-func (w *wallets) SetWallets(wallets map[string]wallet) {
-	w.mx.Lock()
-	defer w.mx.Unlock()
+	wallets = make(map[string]wallet, len(w.wallets))
+	for walletID, wallet := range w.wallets {
+		wallets[walletID] = wallet
+	}
 
-	w.wallets = wallets
+	return wallets
 }
 
 func (w *wallets) SetWallet(walletID string, wallet wallet) {
@@ -51,40 +69,117 @@ func (w *wallets) GetWallet(walletID string) (wallet wallet, err error) {
 	return wallet, nil
 }
 
-func (w *wallets) GetWalletState(walletID string) (state cwalletapi.WalletState, err error) {
+// GetWalletByKey returns the wallet with the config key.
+func (w *wallets) GetWalletByKey(key string) (wallet wallet, ok bool) {
 	w.mx.RLock()
 	defer w.mx.RUnlock()
 
+	for _, wallet := range w.wallets {
+		if wallet.Key == key {
+			return wallet, true
+		}
+	}
+
+	return wallet, false
+}
+
+// SetWalletConfig replaces the config of a wallet, keeping its ID,
+// passphrase and polled state.
+func (w *wallets) SetWalletConfig(walletID string, conf config.WalletConfig) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
 	wallet, ok := w.wallets[walletID]
 	if !ok {
-		return state, fmt.Errorf("wallet not found")
+		return
 	}
 
-	return wallet.state, nil
+	conf.Key = wallet.Key
+	conf.ID = wallet.ID
+	conf.Passphrase = wallet.Passphrase
+	wallet.WalletConfig = conf
+	w.wallets[walletID] = wallet
 }
 
 func (w *wallets) SetWalletState(walletID string, state cwalletapi.WalletState) {
 	w.mx.Lock()
 	defer w.mx.Unlock()
 
-	w.wallets[walletID] = wallet{
-		WalletConfig: w.wallets[walletID].WalletConfig,
-		state:        state,
+	wallet := w.wallets[walletID]
+	wallet.state = state
+	w.wallets[walletID] = wallet
+}
+
+func (w *wallets) SetWalletData(walletID string, data cwalletapi.WalletResponse) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	wallet := w.wallets[walletID]
+	wallet.state = data.State
+	wallet.data = data
+	wallet.polledAt = time.Now().UTC()
+	w.wallets[walletID] = wallet
+}
+
+// GetOrderedWallets returns the wallets ordered by priority and then by
+// config key.
+func (w *wallets) GetOrderedWallets() (wallets []wallet) {
+	w.mx.RLock()
+	defer w.mx.RUnlock()
+
+	for _, w := range w.wallets {
+		wallets = append(wallets, w)
 	}
+
+	sort.Slice(wallets, func(i, j int) bool {
+		if wallets[i].Priority != wallets[j].Priority {
+			return wallets[i].Priority < wallets[j].Priority
+		}
+
+		return wallets[i].Key < wallets[j].Key
+	})
+
+	return wallets
 }
 
+// GetWalletByPolicyID returns the first wallet listing the asset, in the
+// order of GetWalletsByPolicyID.
 func (w *wallets) GetWalletByPolicyID(policyID, assetID string) (wallet wallet, asset config.Asset, err error) {
+	holdings := w.GetWalletsByPolicyID(policyID, assetID)
+	if len(holdings) == 0 {
+		return wallet, asset, fmt.Errorf("wallet not found")
+	}
+
+	return holdings[0].wallet, holdings[0].asset, nil
+}
+
+// holding is a wallet listing an asset.
+type holding struct {
+	wallet wallet
+	asset  config.Asset
+}
+
+// GetWalletsByPolicyID returns every wallet listing the asset, ordered by
+// priority and then by config key.
+func (w *wallets) GetWalletsByPolicyID(policyID, assetID string) (holdings []holding) {
 	w.mx.RLock()
 	defer w.mx.RUnlock()
 
 	for _, w := range w.wallets {
-		assets := w.Assets
-		for _, asset := range assets {
+		for _, asset := range w.Assets {
 			if asset.PolicyID == policyID && asset.AssetID == assetID {
-				return w, asset, nil
+				holdings = append(holdings, holding{wallet: w, asset: asset})
 			}
 		}
 	}
 
-	return wallet, asset, fmt.Errorf("wallet not found")
+	sort.Slice(holdings, func(i, j int) bool {
+		if holdings[i].wallet.Priority != holdings[j].wallet.Priority {
+			return holdings[i].wallet.Priority < holdings[j].wallet.Priority
+		}
+
+		return holdings[i].wallet.Key < holdings[j].wallet.Key
+	})
+
+	return holdings
 }
@@ -0,0 +1,275 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/alert"
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+	"github.com/intellisoftalpin/cardano-wallet-backend/events"
+)
+
+// trackPayouts checks the unsettled payouts of all purchases. Payouts are
+// followed until they are FinalityDepth blocks deep; expired ones are
+// resubmitted, or refunded once they ran out of resubmissions.
+func (t *TransactionRepo) trackPayouts(ctx context.Context) {
+	for _, purchase := range t.purchases.GetUnsettledPayouts() {
+		changed := false
+
+		for i := range purchase.Payouts {
+			if !purchase.Payouts[i].unsettled() {
+				continue
+			}
+
+			if t.trackPayout(ctx, &purchase, i) {
+				changed = true
+			}
+		}
+
+		if changed {
+			t.purchases.SetPurchase(purchase)
+		}
+	}
+}
+
+// trackPayout updates the payout i of the purchase from the status of its
+// latest transaction and reports whether it changed.
+func (t *TransactionRepo) trackPayout(ctx context.Context, purchase *Purchase, i int) bool {
+	payout := &purchase.Payouts[i]
+
+	var tx cwalletapi.Transaction
+
+	attempt := &payout.Attempts[len(payout.Attempts)-1]
+
+	b, err := t.CardanoWalletApi.GetTransaction(ctx, payout.WalletID, payout.TxID)
+	switch {
+	case errors.Is(err, cwalletapi.ErrTxNotFound):
+		// forgotten by the wallet, but a node may still hold it until its
+		// TTL has passed
+		expired, err := t.attemptExpired(ctx, *attempt, payout.TTL)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error tracking payout", "tx_id", payout.TxID, "error", err)
+			return false
+		}
+
+		if !expired {
+			slog.WarnContext(ctx, "Payout unknown to the wallet before its TTL passed", "tx_id", payout.TxID)
+			return false
+		}
+
+		tx.Status = "expired"
+	case err != nil:
+		slog.ErrorContext(ctx, "Error tracking payout", "tx_id", payout.TxID, "error", err)
+		return false
+	default:
+		if err = json.Unmarshal(b, &tx); err != nil {
+			slog.ErrorContext(ctx, "Error tracking payout", "tx_id", payout.TxID, "error", err)
+			return false
+		}
+	}
+
+	changed := attempt.Status != tx.Status
+	attempt.Status = tx.Status
+
+	if payout.Status == PayoutStatusInLedger && tx.Status != "in_ledger" {
+		t.rollbackPayout(ctx, purchase, i, tx.Status)
+		changed = true
+	}
+
+	switch tx.Status {
+	case "in_ledger":
+		changed = changed || payout.Depth != tx.Depth.Quantity
+		payout.Depth = tx.Depth.Quantity
+
+		payout.Status = PayoutStatusInLedger
+		if payout.Depth >= t.payoutConfig.FinalityDepth {
+			payout.Status = PayoutStatusFinal
+		}
+	case "expired":
+		// also retries resubmissions that failed on an earlier poll
+		if uint64(len(payout.Attempts)) <= t.payoutConfig.MaxResubmits {
+			t.resubmitPayout(ctx, purchase, i)
+		} else {
+			t.refundPayout(ctx, purchase, i)
+		}
+
+		changed = true
+	}
+
+	return changed
+}
+
+// rollbackPayout handles a payout that dropped out of the ledger: it is
+// pending again, to be resubmitted should it expire.
+func (t *TransactionRepo) rollbackPayout(ctx context.Context, purchase *Purchase, i int, status string) {
+	payout := &purchase.Payouts[i]
+	payout.Status = PayoutStatusPending
+	payout.Depth = 0
+	payout.Rollbacks++
+
+	slog.WarnContext(ctx, "Payout rolled back", "tx_id", payout.TxID, "purchase_tx_id", purchase.TxID, "status", status)
+
+	t.events.Publish(events.Event{
+		Type:     events.TypePayoutRollback,
+		WalletID: payout.WalletID,
+		Status:   status,
+		TxID:     payout.TxID,
+		Message:  "payout of purchase " + purchase.TxID + " rolled back",
+	})
+
+	t.alerts.notifier.Notify(ctx, alert.Alert{
+		Kind:     alert.KindRollback,
+		Key:      alert.KindRollback + "/" + payout.TxID + "/" + fmt.Sprint(payout.Rollbacks),
+		WalletID: payout.WalletID,
+		TxID:     payout.TxID,
+		Message:  fmt.Sprintf("payout %s of purchase %s rolled back, now %s", payout.TxID, purchase.TxID, status),
+	})
+}
+
+// resubmitPayout rebuilds the expired payout i of the purchase and submits it
+// again.
+func (t *TransactionRepo) resubmitPayout(ctx context.Context, purchase *Purchase, i int) {
+	payout := &purchase.Payouts[i]
+
+	if len(payout.Payments) == 0 {
+		// recorded before payouts could be rebuilt
+		t.refundPayout(ctx, purchase, i)
+		return
+	}
+
+	wallet, err := t.wallets.GetWallet(payout.WalletID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error resubmitting payout", "tx_id", payout.TxID, "error", err)
+		return
+	}
+
+	if err = t.sweeps.payoutStarted(ctx, wallet.ID); err != nil {
+		slog.ErrorContext(ctx, "Error resubmitting payout", "tx_id", payout.TxID, "error", err)
+		return
+	}
+	defer t.sweeps.payoutDone(wallet.ID)
+
+	req := cwalletapi.CreateTransactionRequest{
+		Passphrase: wallet.Passphrase,
+		Payments:   payout.Payments,
+		Withdrawal: "self",
+		TimeToLive: cwalletapi.Quantity{
+			Quantity: payout.TTL,
+			Unit:     "second",
+		},
+	}
+
+	_, newTx, err := t.CardanoWalletApi.CreateTransaction(ctx, wallet.ID, req)
+	if err != nil {
+		// the next poll tries again, the expired attempt still counts
+		slog.ErrorContext(ctx, "Error resubmitting payout", "tx_id", payout.TxID, "error", err)
+		return
+	}
+
+	payout.Attempts = append(payout.Attempts, PayoutAttempt{
+		TxID:          newTx.ID,
+		Status:        newTx.Status,
+		SubmittedAt:   time.Now().UTC(),
+		ExpiresAtSlot: newTx.ExpiresAt.AbsoluteSlotNumber,
+	})
+
+	if purchase.PayoutTxID == payout.TxID {
+		purchase.PayoutTxID = newTx.ID
+	}
+
+	payout.TxID = newTx.ID
+	payout.Fee = newTx.Fee.Quantity
+}
+
+// attemptExpired reports whether the payout attempt can no longer land: the
+// network tip is past its TTL slot or, for attempts recorded without one,
+// past its submission plus ttl seconds.
+func (t *TransactionRepo) attemptExpired(ctx context.Context, attempt PayoutAttempt, ttl uint64) (bool, error) {
+	networkInfo, err := t.CardanoWalletApi.GetWalletNetworkInformation(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	tip := networkInfo.NetworkTip
+
+	if attempt.ExpiresAtSlot > 0 {
+		return tip.AbsoluteSlotNumber > attempt.ExpiresAtSlot, nil
+	}
+
+	tipTime, err := time.Parse(time.RFC3339, tip.Time)
+	if err != nil {
+		return false, fmt.Errorf("parsing network tip time: %w", err)
+	}
+
+	if ttl == 0 {
+		ttl = t.payoutConfig.TTLSeconds
+	}
+
+	return tipTime.After(attempt.SubmittedAt.Add(time.Duration(ttl) * time.Second)), nil
+}
+
+// refundPayout gives up on the payout i of the purchase and refunds what the
+// buyer paid for its items. Should no refund be recorded, the payout is left
+// to an operator.
+func (t *TransactionRepo) refundPayout(ctx context.Context, purchase *Purchase, i int) {
+	payout := &purchase.Payouts[i]
+	purchase.Error = ErrPayoutExpired.Error()
+
+	if t.recordRefund(ctx, purchase.WalletID, payout.WalletID, purchase.TxID, i, purchase.RefundAddress, ErrPayoutExpired) {
+		payout.Status = PayoutStatusRefunded
+	} else {
+		payout.Status = PayoutStatusManual
+		t.alertPayoutManual(ctx, *purchase, i)
+	}
+
+	purchase.Status = PurchaseStatusFailed
+	for _, p := range purchase.Payouts {
+		if !p.abandoned() {
+			purchase.Status = PurchaseStatusPartial
+		}
+	}
+}
+
+// alertPayoutManual raises an alert for the payout i of the purchase that
+// expired and couldn't be refunded.
+func (t *TransactionRepo) alertPayoutManual(ctx context.Context, purchase Purchase, i int) {
+	payout := purchase.Payouts[i]
+
+	slog.WarnContext(ctx, "Payout needs manual handling", "tx_id", payout.TxID, "purchase_tx_id", purchase.TxID)
+
+	t.alerts.notifier.Notify(ctx, alert.Alert{
+		Kind:     alert.KindPayoutManual,
+		Key:      alert.KindPayoutManual + "/" + refundID(purchase.TxID, i),
+		WalletID: payout.WalletID,
+		TxID:     payout.TxID,
+		Message:  fmt.Sprintf("payout %s of purchase %s expired and wasn't refunded, it needs manual handling", payout.TxID, purchase.TxID),
+	})
+}
+
+// payoutValue returns the lovelace the buyer paid for the items of payout i:
+// their price, deposit and processing fee, and for the first payout also the
+// surplus unless it was kept.
+func payoutValue(purchase Purchase, i int) (value uint64) {
+	walletID := purchase.Payouts[i].WalletID
+
+	var due uint64
+	for _, item := range purchase.Items {
+		cost := item.Price + item.Deposit + item.ProcessingFee
+		due += cost
+
+		if item.WalletID == walletID {
+			value += cost
+		}
+	}
+
+	if i == 0 && purchase.Received > due && purchase.SurplusPolicy != config.OverpaymentKeep {
+		value += purchase.Received - due
+	}
+
+	return value
+}
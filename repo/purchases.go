@@ -0,0 +1,376 @@
+package repo
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bykovme/goconfig"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+)
+
+const purchasesFile = "purchases.json"
+
+const (
+	PurchaseStatusProcessing = "processing"
+	PurchaseStatusPaid       = "paid"
+	PurchaseStatusPartial    = "partially_paid"
+	PurchaseStatusFailed     = "failed"
+)
+
+const (
+	PayoutStatusPending  = "pending"
+	PayoutStatusInLedger = "in_ledger"
+	PayoutStatusFinal    = "final"
+	PayoutStatusRefunded = "refunded"
+
+	// PayoutStatusManual is an expired payout that couldn't be refunded,
+	// left to an operator.
+	PayoutStatusManual = "needs_manual_handling"
+)
+
+// Purchase records how a buyer's transaction was settled. Purchases are keyed
+// by the buyer's transaction ID.
+type Purchase struct {
+	TxID     string `json:"tx_id"`
+	WalletID string `json:"wallet_id"`
+	Address  string `json:"address"`
+
+	// RefundAddress is where the purchase is paid back should it fail.
+	RefundAddress string `json:"refund_address"`
+
+	Items []PurchaseItem `json:"items"`
+
+	// Buyer identifies the owner of the payout address, see buyerKey.
+	Buyer string `json:"buyer"`
+
+	// Received is the lovelace paid to the wallet; Price, Deposit and
+	// ProcessingFee are the totals of all items.
+	Received      uint64 `json:"received"`
+	Price         uint64 `json:"price"`
+	Deposit       uint64 `json:"deposit"`
+	ProcessingFee uint64 `json:"processing_fee"`
+
+	// Surplus is the lovelace paid above price, deposit and processing fee,
+	// and SurplusPolicy is the overpayment policy that was applied to it.
+	Surplus       uint64 `json:"surplus"`
+	SurplusPolicy string `json:"surplus_policy"`
+
+	Status string `json:"status"`
+
+	// Epoch is the epoch the purchase was processed in, for accounting.
+	Epoch uint64 `json:"epoch"`
+
+	// PayoutTxID is the payout of the wallet that received the payment, and
+	// Payouts lists the payouts of every wallet in the purchase.
+	PayoutTxID string           `json:"payout_tx_id,omitempty"`
+	Payouts    []PurchasePayout `json:"payouts,omitempty"`
+	Error      string           `json:"error,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type PurchaseItem struct {
+	WalletID      string `json:"wallet_id"`
+	PolicyID      string `json:"policy_id"`
+	AssetID       string `json:"asset_id"`
+	Lots          uint64 `json:"lots"`
+	AssetQuantity uint64 `json:"asset_quantity"`
+	Price         uint64 `json:"price"`
+	Deposit       uint64 `json:"deposit"`
+	ProcessingFee uint64 `json:"processing_fee"`
+}
+
+// PurchasePayout is the payout of one wallet's items. TxID is its latest
+// transaction; Attempts lists every transaction submitted for it, the first
+// being the original and the rest resubmissions after expiry.
+type PurchasePayout struct {
+	WalletID string `json:"wallet_id"`
+	TxID     string `json:"tx_id"`
+	Lovelace uint64 `json:"lovelace"`
+
+	// Fee is the network fee of the payout transaction.
+	Fee uint64 `json:"fee"`
+
+	Status   string          `json:"status,omitempty"`
+	Attempts []PayoutAttempt `json:"attempts,omitempty"`
+
+	// Depth is the number of blocks on top of the payout once it is in the
+	// ledger, and Rollbacks counts how often it dropped out again.
+	Depth     uint64 `json:"depth"`
+	Rollbacks uint64 `json:"rollbacks,omitempty"`
+
+	// Payments and TTL rebuild the payout when it expires.
+	Payments []cwalletapi.Payment `json:"payments,omitempty"`
+	TTL      uint64               `json:"ttl,omitempty"`
+}
+
+type PayoutAttempt struct {
+	TxID        string    `json:"tx_id"`
+	Status      string    `json:"status"`
+	SubmittedAt time.Time `json:"submitted_at"`
+
+	// ExpiresAtSlot is the slot after which the transaction can no longer
+	// land, zero if unknown.
+	ExpiresAtSlot uint64 `json:"expires_at_slot,omitempty"`
+}
+
+type purchases struct {
+	mx        *sync.RWMutex
+	file      string
+	purchases map[string]Purchase
+}
+
+type purchasesState struct {
+	Purchases map[string]Purchase `json:"purchases"`
+}
+
+func loadPurchases(stateDir string) purchases {
+	file := filepath.Join(stateDir, purchasesFile)
+
+	state := purchasesState{}
+
+	if err := goconfig.LoadConfig(file, &state); err != nil {
+		slog.Info("No purchases state found", "file", file)
+	}
+
+	if state.Purchases == nil {
+		state.Purchases = make(map[string]Purchase)
+	}
+
+	return purchases{
+		mx:        &sync.RWMutex{},
+		file:      file,
+		purchases: state.Purchases,
+	}
+}
+
+func (p *purchases) GetPurchase(txID string) (purchase Purchase, ok bool) {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	purchase, ok = p.purchases[txID]
+
+	return purchase, ok
+}
+
+func (p *purchases) GetPurchases() (purchases []Purchase) {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	for _, purchase := range p.purchases {
+		purchases = append(purchases, purchase)
+	}
+
+	sort.Slice(purchases, func(i, j int) bool {
+		return purchases[i].CreatedAt.Before(purchases[j].CreatedAt)
+	})
+
+	return purchases
+}
+
+// GetUnsettledPayouts returns the purchases with payouts that aren't final
+// yet.
+func (p *purchases) GetUnsettledPayouts() (purchases []Purchase) {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	for _, purchase := range p.purchases {
+		for _, payout := range purchase.Payouts {
+			if payout.unsettled() {
+				purchases = append(purchases, purchase)
+				break
+			}
+		}
+	}
+
+	return purchases
+}
+
+// Sold returns the lots of the asset paid out in total and to buyer.
+func (p *purchases) Sold(policyID, assetID, buyer string) (total, byBuyer uint64) {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	for _, purchase := range p.purchases {
+		for _, item := range purchase.soldItems() {
+			if item.PolicyID != policyID || item.AssetID != assetID {
+				continue
+			}
+
+			total += item.Lots
+			if buyer != "" && purchase.Buyer == buyer {
+				byBuyer += item.Lots
+			}
+		}
+	}
+
+	return total, byBuyer
+}
+
+// SoldTotals returns the lots paid out in total per asset, keyed by
+// policyID.assetID.
+func (p *purchases) SoldTotals() map[string]uint64 {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	totals := make(map[string]uint64)
+
+	for _, purchase := range p.purchases {
+		for _, item := range purchase.soldItems() {
+			totals[item.PolicyID+"."+item.AssetID] += item.Lots
+		}
+	}
+
+	return totals
+}
+
+// soldItems returns the items of the purchase that were paid out.
+func (p Purchase) soldItems() (items []PurchaseItem) {
+	if p.Status != PurchaseStatusPaid && p.Status != PurchaseStatusPartial {
+		return nil
+	}
+
+	for _, item := range p.Items {
+		if !p.refunded(item.WalletID) {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// FindPayout returns the payout one of whose attempts is the transaction
+// txID.
+func (p *purchases) FindPayout(txID string) (payout PurchasePayout, ok bool) {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	for _, purchase := range p.purchases {
+		for _, payout := range purchase.Payouts {
+			for _, attempt := range payout.Attempts {
+				if attempt.TxID == txID {
+					return payout, true
+				}
+			}
+		}
+	}
+
+	return payout, false
+}
+
+func (p PurchasePayout) unsettled() bool {
+	return p.Status == PayoutStatusPending || p.Status == PayoutStatusInLedger
+}
+
+// firstItem returns the first item of the purchase the wallet pays out.
+func (p Purchase) firstItem(walletID string) (item PurchaseItem, ok bool) {
+	for _, item = range p.Items {
+		if item.WalletID == walletID {
+			return item, true
+		}
+	}
+
+	return item, false
+}
+
+// refunded reports whether the payout of the wallet's items was refunded
+// instead, or given up on and left to an operator.
+func (p Purchase) refunded(walletID string) bool {
+	for _, payout := range p.Payouts {
+		if payout.WalletID == walletID {
+			return payout.abandoned()
+		}
+	}
+
+	return false
+}
+
+// abandoned reports whether the payout expired for good.
+func (p PurchasePayout) abandoned() bool {
+	return p.Status == PayoutStatusRefunded || p.Status == PayoutStatusManual
+}
+
+// claim records the purchase as processing, unless it is already being
+// processed or was paid out, so a replayed purchase isn't paid twice. A
+// purchase that failed without payouts can be claimed again; one left
+// processing by a crash is left to the operator.
+func (p *purchases) claim(purchase Purchase) bool {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if existing, ok := p.purchases[purchase.TxID]; ok && (existing.Status != PurchaseStatusFailed || len(existing.Payouts) > 0) {
+		return false
+	}
+
+	purchase.Status = PurchaseStatusProcessing
+	p.set(purchase)
+
+	return true
+}
+
+func (p *purchases) SetPurchase(purchase Purchase) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	p.set(purchase)
+}
+
+func (p *purchases) set(purchase Purchase) {
+	if purchase.CreatedAt.IsZero() {
+		purchase.CreatedAt = time.Now().UTC()
+	}
+
+	purchase.UpdatedAt = time.Now().UTC()
+	p.purchases[purchase.TxID] = purchase
+
+	if err := goconfig.SaveConfig(p.file, purchasesState{Purchases: p.purchases}); err != nil {
+		slog.Error("Error saving purchases", "error", err)
+	}
+}
+
+// ----------------------------------------------------------------------
+
+// settleSurplus returns the lovelace received above what is due and the
+// overpayment policy of asset that applies to it. Surplus up to the asset's
+// dust threshold is always kept.
+func settleSurplus(received, due uint64, asset config.Asset) (surplus uint64, policy string) {
+	if received <= due {
+		return 0, config.OverpaymentKeep
+	}
+
+	surplus = received - due
+	if surplus <= asset.OverpaymentDust {
+		return surplus, config.OverpaymentKeep
+	}
+
+	if asset.OverpaymentPolicy == config.OverpaymentDonate && asset.DonationAddress == "" {
+		return surplus, config.OverpaymentKeep
+	}
+
+	return surplus, asset.OverpaymentPolicy
+}
+
+func (t *TransactionRepo) GetPurchases() []Purchase {
+	return t.purchases.GetPurchases()
+}
+
+func (t *TransactionRepo) GetPurchase(txID string) (purchase Purchase, err error) {
+	purchase, ok := t.purchases.GetPurchase(txID)
+	if !ok {
+		return purchase, fmt.Errorf("purchase not found")
+	}
+
+	return purchase, nil
+}
+
+// GetPayout returns the payout the transaction txID was submitted for.
+func (t *TransactionRepo) GetPayout(txID string) (payout PurchasePayout, ok bool) {
+	return t.purchases.FindPayout(txID)
+}
@@ -0,0 +1,552 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bykovme/goconfig"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+	"github.com/intellisoftalpin/cardano-wallet-backend/metadata"
+)
+
+const refundsFile = "refunds.json"
+
+const (
+	// RefundStatusAwaitingPurchase is a refund whose purchase transaction
+	// hasn't been seen in the ledger by the wallet it paid yet, see
+	// confirmRefund.
+	RefundStatusAwaitingPurchase = "awaiting_purchase"
+	RefundStatusNotReceived      = "purchase_not_received"
+
+	RefundStatusPending    = "pending_approval"
+	RefundStatusSubmitting = "submitting"
+	RefundStatusSubmitted  = "submitted"
+	RefundStatusRejected   = "rejected"
+	RefundStatusFailed     = "failed"
+)
+
+// wholePurchase is the payout index of a refund of a whole purchase.
+const wholePurchase = -1
+
+// Refund is a payment back to the buyer of a purchase that couldn't be
+// fulfilled. Refunds are keyed by ID, see refundID, so a purchase or payout
+// is never refunded twice.
+type Refund struct {
+	ID           string `json:"id"`
+	PurchaseTxID string `json:"purchase_tx_id"`
+	WalletID     string `json:"wallet_id"`
+	Address      string `json:"address"`
+	Received     uint64 `json:"received"`
+	Fee          uint64 `json:"fee"`
+	Amount       uint64 `json:"amount"`
+	Reason       string `json:"reason"`
+	Status       string `json:"status"`
+	RefundTxID   string `json:"refund_tx_id,omitempty"`
+	Error        string `json:"error,omitempty"`
+
+	// ReceivedBy is the wallet the purchase paid, and Payout the index of the
+	// payout refunded, or wholePurchase.
+	ReceivedBy string `json:"received_by"`
+	Payout     int    `json:"payout"`
+
+	// NetworkFee is the network fee of the refund transaction and Epoch the
+	// epoch it was recorded in, for accounting.
+	NetworkFee uint64 `json:"network_fee"`
+	Epoch      uint64 `json:"epoch"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type refunds struct {
+	mx      *sync.RWMutex
+	file    string
+	refunds map[string]Refund
+
+	// submitting are the refunds being submitted by this process.
+	submitting map[string]bool
+}
+
+type refundsState struct {
+	Refunds map[string]Refund `json:"refunds"`
+}
+
+func loadRefunds(stateDir string) refunds {
+	file := filepath.Join(stateDir, refundsFile)
+
+	state := refundsState{}
+
+	if err := goconfig.LoadConfig(file, &state); err != nil {
+		slog.Info("No refunds state found", "file", file)
+	}
+
+	if state.Refunds == nil {
+		state.Refunds = make(map[string]Refund)
+	}
+
+	// recorded before refunds had an ID or a payout index, keyed by the
+	// purchase they refund
+	for id, refund := range state.Refunds {
+		if refund.ID == "" {
+			refund.ID = id
+		}
+
+		if refund.ID == refund.PurchaseTxID {
+			refund.Payout = wholePurchase
+		}
+
+		state.Refunds[id] = refund
+	}
+
+	return refunds{
+		mx:         &sync.RWMutex{},
+		file:       file,
+		refunds:    state.Refunds,
+		submitting: make(map[string]bool),
+	}
+}
+
+// refundID returns the ID of the refund of the purchase purchaseTxID, or of
+// its payout when payout isn't wholePurchase.
+func refundID(purchaseTxID string, payout int) string {
+	if payout == wholePurchase {
+		return purchaseTxID
+	}
+
+	return fmt.Sprintf("%s#%d", purchaseTxID, payout)
+}
+
+func (r *refunds) GetRefund(id string) (refund Refund, ok bool) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	refund, ok = r.refunds[id]
+
+	return refund, ok
+}
+
+func (r *refunds) GetRefunds() (refunds []Refund) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	for _, refund := range r.refunds {
+		refunds = append(refunds, refund)
+	}
+
+	sort.Slice(refunds, func(i, j int) bool {
+		return refunds[i].CreatedAt.Before(refunds[j].CreatedAt)
+	})
+
+	return refunds
+}
+
+func (r *refunds) SetRefund(refund Refund) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.set(refund)
+}
+
+// claim moves the refund to submitting, so it is submitted once however
+// many approvals race, and returns it as it was. A refund left submitting
+// by an earlier process can be claimed again.
+func (r *refunds) claim(key string) (refund Refund, err error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	refund, ok := r.refunds[key]
+	if !ok {
+		return refund, fmt.Errorf("refund not found")
+	}
+
+	if r.submitting[key] {
+		return refund, fmt.Errorf("refund is being submitted")
+	}
+
+	switch refund.Status {
+	case RefundStatusPending, RefundStatusFailed, RefundStatusSubmitting:
+	default:
+		return refund, fmt.Errorf("refund is %s", refund.Status)
+	}
+
+	if refund.Address == "" || refund.Amount == 0 {
+		return refund, fmt.Errorf("refund can't be paid: %s", refund.Error)
+	}
+
+	r.submitting[key] = true
+
+	claimed := refund
+	claimed.Status = RefundStatusSubmitting
+	r.set(claimed)
+
+	return refund, nil
+}
+
+// done ends the submission claimed, saving the refund as it ended.
+func (r *refunds) done(key string, refund Refund) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	delete(r.submitting, key)
+	r.set(refund)
+}
+
+// reject marks a refund that isn't being or wasn't submitted as rejected.
+func (r *refunds) reject(key string) (refund Refund, err error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	refund, ok := r.refunds[key]
+	if !ok {
+		return refund, fmt.Errorf("refund not found")
+	}
+
+	if r.submitting[key] || refund.Status == RefundStatusSubmitting || refund.Status == RefundStatusSubmitted {
+		return refund, fmt.Errorf("refund is %s", refund.Status)
+	}
+
+	refund.Status = RefundStatusRejected
+	r.set(refund)
+
+	return refund, nil
+}
+
+// paidBy returns the ID of the refund recorded as paid by the transaction
+// txID, if any.
+func (r *refunds) paidBy(txID string) (id string, ok bool) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	for id, refund := range r.refunds {
+		if refund.RefundTxID == txID {
+			return id, true
+		}
+	}
+
+	return "", false
+}
+
+func (r *refunds) set(refund Refund) {
+	refund.UpdatedAt = time.Now().UTC()
+	r.refunds[refund.ID] = refund
+
+	if err := goconfig.SaveConfig(r.file, refundsState{Refunds: r.refunds}); err != nil {
+		slog.Error("Error saving refunds", "error", err)
+	}
+}
+
+// ----------------------------------------------------------------------
+
+// refundPurchase records a refund for a purchase that failed with reason.
+func (t *TransactionRepo) refundPurchase(ctx context.Context, wallet wallet, tx cwalletapi.Transaction, reason error) {
+	t.recordRefund(ctx, wallet.ID, wallet.ID, tx.ID, wholePurchase, refundAddress(tx), reason)
+}
+
+// recordRefund records a refund from the wallet walletID of the purchase
+// purchaseTxID that paid the wallet receivedBy, or of its payout when payout
+// isn't wholePurchase, to address. A whole purchase that already has payouts
+// submitted isn't refunded, its payouts are refunded one by one should they
+// expire.
+//
+// The refund waits for the purchase to be in the ledger, see confirmRefund,
+// and is then paid out right away in auto mode. It reports whether a refund
+// that can be paid is recorded, now or earlier; if not, the buyer is owed
+// lovelace nobody will pay back unless an operator steps in.
+func (t *TransactionRepo) recordRefund(ctx context.Context, receivedBy, walletID, purchaseTxID string, payout int, address string, reason error) bool {
+	if !t.refundConfig.Enabled {
+		return false
+	}
+
+	if purchase, ok := t.purchases.GetPurchase(purchaseTxID); ok && payout == wholePurchase && len(purchase.Payouts) > 0 {
+		slog.WarnContext(ctx, "Not refunding a purchase with payouts submitted", "purchase_tx_id", purchaseTxID, "reason", reason)
+		return false
+	}
+
+	id := refundID(purchaseTxID, payout)
+
+	if existing, ok := t.refunds.GetRefund(id); ok {
+		return existing.Status == RefundStatusAwaitingPurchase || existing.Address != "" && existing.Amount > 0
+	}
+
+	refund := Refund{
+		ID:           id,
+		PurchaseTxID: purchaseTxID,
+		WalletID:     walletID,
+		ReceivedBy:   receivedBy,
+		Payout:       payout,
+		Address:      address,
+		Fee:          t.refundConfig.Fee,
+		Reason:       reason.Error(),
+		Status:       RefundStatusAwaitingPurchase,
+		Epoch:        t.currentEpoch(ctx),
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	if refund.Address == "" {
+		refund.Status = RefundStatusFailed
+		refund.Error = "refund address unknown"
+		t.refunds.SetRefund(refund)
+
+		return false
+	}
+
+	t.refunds.SetRefund(refund)
+	t.confirmRefund(ctx, refund)
+
+	return true
+}
+
+// confirmRefunds checks the refunds awaiting their purchase.
+func (t *TransactionRepo) confirmRefunds(ctx context.Context) {
+	for _, refund := range t.refunds.GetRefunds() {
+		if refund.Status == RefundStatusAwaitingPurchase {
+			t.confirmRefund(ctx, refund)
+		}
+	}
+}
+
+// confirmRefund makes the refund payable once the wallet the purchase paid
+// has it as an incoming transaction in the ledger, for the lovelace the
+// wallet received, and in auto mode pays it out. A purchase that expired,
+// didn't pay the wallet, or that the wallet still doesn't know a payout TTL
+// after the refund was recorded, is never refunded.
+func (t *TransactionRepo) confirmRefund(ctx context.Context, refund Refund) {
+	var tx cwalletapi.Transaction
+
+	b, err := t.CardanoWalletApi.GetTransaction(ctx, refund.ReceivedBy, refund.PurchaseTxID)
+	if err == nil {
+		err = json.Unmarshal(b, &tx)
+	}
+
+	ttl := time.Duration(t.payoutConfig.TTLSeconds) * time.Second
+
+	switch {
+	case errors.Is(err, cwalletapi.ErrTxNotFound):
+		if time.Since(refund.CreatedAt) < ttl {
+			return
+		}
+
+		refund.Status = RefundStatusNotReceived
+		refund.Error = "purchase transaction unknown to the wallet"
+	case err != nil:
+		slog.ErrorContext(ctx, "Error confirming refund", "refund_id", refund.ID, "error", err)
+		return
+	case tx.Direction != "incoming":
+		refund.Status = RefundStatusNotReceived
+		refund.Error = "purchase transaction didn't pay the wallet"
+	case tx.Status == "expired":
+		refund.Status = RefundStatusNotReceived
+		refund.Error = "purchase transaction expired"
+	case tx.Status != "in_ledger":
+		return
+	default:
+		t.settleRefund(&refund, tx.Amount.Quantity)
+	}
+
+	t.refunds.SetRefund(refund)
+
+	if refund.Status == RefundStatusPending && t.refundConfig.Mode == config.RefundModeAuto {
+		if _, err := t.ApproveRefund(ctx, refund.ID); err != nil {
+			slog.ErrorContext(ctx, "Error refunding purchase", "refund_id", refund.ID, "error", err)
+		}
+	}
+}
+
+// settleRefund sets the amount of the refund from the lovelace the wallet
+// received for the purchase: all of it, or for a payout what the buyer paid
+// for its items.
+func (t *TransactionRepo) settleRefund(refund *Refund, received uint64) {
+	refund.Received = received
+
+	if refund.Payout != wholePurchase {
+		purchase, ok := t.purchases.GetPurchase(refund.PurchaseTxID)
+		if !ok || refund.Payout >= len(purchase.Payouts) {
+			refund.Status = RefundStatusFailed
+			refund.Error = "purchase of the refunded payout not found"
+			return
+		}
+
+		purchase.Received = received
+		if value := payoutValue(purchase, refund.Payout); value < received {
+			refund.Received = value
+		}
+	}
+
+	refund.Status = RefundStatusPending
+	refund.Error = ""
+
+	if refund.Received > refund.Fee {
+		refund.Amount = refund.Received - refund.Fee
+	}
+
+	if refund.Amount == 0 {
+		refund.Status = RefundStatusFailed
+		refund.Error = "received amount doesn't cover refund fee"
+	}
+}
+
+// ApproveRefund pays out a pending refund. A failed one is paid out again
+// only once the wallet shows none of its earlier attempts landed.
+func (t *TransactionRepo) ApproveRefund(ctx context.Context, id string) (refund Refund, err error) {
+	refund, err = t.refunds.claim(id)
+	if err != nil {
+		return refund, err
+	}
+	defer func() {
+		t.refunds.done(id, refund)
+	}()
+
+	wallet, err := t.wallets.GetWallet(refund.WalletID)
+	if err != nil {
+		return refund, err
+	}
+
+	// the wallet isn't swept while the refund is built
+	if err = t.sweeps.payoutStarted(ctx, wallet.ID); err != nil {
+		return refund, err
+	}
+	defer t.sweeps.payoutDone(wallet.ID)
+
+	if refund.Status != RefundStatusPending {
+		tx, found, err := t.refundOnChain(ctx, wallet.ID, refund)
+		if err != nil {
+			return refund, fmt.Errorf("checking earlier refund attempts: %w", err)
+		}
+
+		if found {
+			slog.InfoContext(ctx, "Earlier refund attempt found on chain", "refund_id", id, "tx_id", tx.ID)
+
+			refund.Status = RefundStatusSubmitted
+			refund.RefundTxID = tx.ID
+			refund.NetworkFee = tx.Fee.Quantity
+			refund.Error = ""
+
+			return refund, nil
+		}
+	}
+
+	req := cwalletapi.CreateTransactionRequest{
+		Passphrase: wallet.Passphrase,
+		Payments: []cwalletapi.Payment{
+			{
+				Address: refund.Address,
+				Amount: cwalletapi.Quantity{
+					Quantity: refund.Amount,
+					Unit:     "lovelace",
+				},
+			},
+		},
+		// links the refund to the purchase on chain
+		Metadata: metadata.EncodeRefund(refund.PurchaseTxID),
+		TimeToLive: cwalletapi.Quantity{
+			Quantity: t.payoutConfig.TTLSeconds,
+			Unit:     "second",
+		},
+	}
+
+	_, newTx, err := t.CardanoWalletApi.CreateTransaction(ctx, wallet.ID, req)
+	if err != nil {
+		refund.Status = RefundStatusFailed
+		refund.Error = err.Error()
+
+		return refund, err
+	}
+
+	refund.Status = RefundStatusSubmitted
+	refund.RefundTxID = newTx.ID
+	refund.NetworkFee = newTx.Fee.Quantity
+	refund.Error = ""
+
+	return refund, nil
+}
+
+// refundOnChain looks for an earlier attempt at the refund the wallet sent
+// and that didn't expire: the transaction recorded, or one with the refund's
+// metadata paying its amount to its address that no other refund of the
+// purchase is recorded as paid by.
+func (t *TransactionRepo) refundOnChain(ctx context.Context, walletID string, refund Refund) (tx cwalletapi.Transaction, found bool, err error) {
+	txs, err := t.CardanoWalletApi.ListTransactions(ctx, walletID)
+	if err != nil {
+		return tx, false, err
+	}
+
+	for _, tx := range txs {
+		if tx.Direction != "outgoing" || tx.Status == "expired" {
+			continue
+		}
+
+		if tx.ID == refund.RefundTxID {
+			return tx, true, nil
+		}
+
+		if paysRefund(tx, refund) {
+			if id, ok := t.refunds.paidBy(tx.ID); !ok || id == refund.ID {
+				return tx, true, nil
+			}
+		}
+	}
+
+	return tx, false, nil
+}
+
+func paysRefund(tx cwalletapi.Transaction, refund Refund) bool {
+	if tx.Metadata[metadata.LabelRefundOf].String != refund.PurchaseTxID {
+		return false
+	}
+
+	for _, output := range tx.Outputs {
+		if output.Address == refund.Address && output.Amount.Quantity == refund.Amount {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RejectRefund marks a pending refund as rejected by an admin.
+func (t *TransactionRepo) RejectRefund(id string) (refund Refund, err error) {
+	return t.refunds.reject(id)
+}
+
+func (t *TransactionRepo) GetRefunds() []Refund {
+	return t.refunds.GetRefunds()
+}
+
+// ----------------------------------------------------------------------
+
+// refundAddress returns the refund address from the purchase metadata, or
+// else the address of the purchase's first input.
+func refundAddress(tx cwalletapi.Transaction) string {
+	if address := metadata.RefundAddress(tx.Metadata); address != "" {
+		return address
+	}
+
+	for _, input := range tx.Inputs {
+		if input.Address != "" {
+			return input.Address
+		}
+	}
+
+	return ""
+}
+
+// receivedLovelace sums the purchase outputs paid to the wallet's own
+// addresses, which cardano-wallet marks with a derivation path.
+func receivedLovelace(tx cwalletapi.Transaction) (received uint64) {
+	for _, output := range tx.Outputs {
+		if len(output.DerivationPath) > 0 {
+			received += output.Amount.Quantity
+		}
+	}
+
+	return received
+}
@@ -0,0 +1,139 @@
+package repo
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"sync"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+	"github.com/intellisoftalpin/cardano-wallet-backend/logging"
+)
+
+// restores tracks the wallets added by a reload that are being restored.
+type restores struct {
+	mx      *sync.Mutex
+	running map[string]bool
+}
+
+func newRestores() restores {
+	return restores{
+		mx:      &sync.Mutex{},
+		running: make(map[string]bool),
+	}
+}
+
+// start reports whether the wallet with the config key isn't being restored
+// already, and if so marks it.
+func (r *restores) start(key string) bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.running[key] {
+		return false
+	}
+
+	r.running[key] = true
+
+	return true
+}
+
+func (r *restores) done(key string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	delete(r.running, key)
+}
+
+// ----------------------------------------------------------------------
+
+// ApplyConfig applies the wallets of a reloaded config to the running ones.
+// Changed assets, prices, fees, buffers, priorities and sweeps take effect
+// right away. New wallets are restored in the background and polled once
+// restored. Wallets removed from the config stop selling, but stay known so
+// their payouts and refunds can still be tracked.
+func (t *TransactionRepo) ApplyConfig(conf *config.Config) {
+	for key, next := range conf.Wallets {
+		w, ok := t.wallets.GetWalletByKey(key)
+		if !ok {
+			next.Key = key
+
+			conf := next
+			t.workers.Go("restore wallet "+key, func(ctx context.Context) error {
+				t.restoreWallet(logging.WithRequestID(ctx, logging.NewRequestID()), conf)
+				return nil
+			})
+
+			continue
+		}
+
+		if next.Mnemonic != w.Mnemonic {
+			slog.Warn("Config: a wallet mnemonic changed, it applies to new wallets only", "wallet", key)
+			next.Mnemonic = w.Mnemonic
+		}
+
+		if !walletConfigChanged(w.WalletConfig, next) {
+			continue
+		}
+
+		t.wallets.SetWalletConfig(w.ID, next)
+		slog.Info("Config: updated wallet", "wallet", key)
+	}
+
+	for _, w := range t.wallets.GetOrderedWallets() {
+		if _, ok := conf.Wallets[w.Key]; ok || len(w.Assets) == 0 {
+			continue
+		}
+
+		removed := w.WalletConfig
+		removed.Assets = nil
+		t.wallets.SetWalletConfig(w.ID, removed)
+		slog.Info("Config: wallet was removed, it no longer sells", "wallet", w.Key)
+	}
+}
+
+// walletConfigChanged compares the configs of a wallet, leaving out the
+// fields not read from the config file.
+func walletConfigChanged(running, next config.WalletConfig) bool {
+	next.Key = running.Key
+	next.ID = running.ID
+	next.Passphrase = running.Passphrase
+
+	return !reflect.DeepEqual(running, next)
+}
+
+// restoreWallet restores a wallet added to the config in cardano-wallet and
+// adds it to the running wallets, syncing until it is polled ready. A wallet
+// that can't be restored is tried again on the next reload.
+func (t *TransactionRepo) restoreWallet(ctx context.Context, conf config.WalletConfig) {
+	if !t.restores.start(conf.Key) {
+		return
+	}
+	defer t.restores.done(conf.Key)
+
+	slog.InfoContext(ctx, "Config: restoring new wallet", "wallet", conf.Key)
+
+	restored, err := t.CardanoWalletApi.GetWalletsPasswords(ctx, map[string]config.WalletConfig{conf.Key: conf})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error restoring wallet", "wallet", conf.Key, "error", err)
+		return
+	}
+
+	w, ok := restored[conf.Key]
+	if !ok {
+		slog.ErrorContext(ctx, "Error restoring wallet: no mnemonic or cardano-wallet refused it", "wallet", conf.Key)
+		return
+	}
+
+	w.Key = conf.Key
+
+	t.wallets.SetWallet(w.ID, wallet{
+		WalletConfig: w,
+		state: cwalletapi.WalletState{
+			Status: "syncing",
+		},
+	})
+
+	slog.InfoContext(ctx, "Config: added wallet", "wallet", conf.Key, "wallet_id", w.ID)
+}
@@ -0,0 +1,225 @@
+package repo
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+)
+
+// Reservation holds stock for a purchase from CheckTokenBalance until
+// CreateTransaction pays it out, so concurrent buyers can't be sold the same
+// tokens. Its ID is the purchase transaction ID.
+type Reservation struct {
+	ID        string         `json:"id"`
+	Buyer     string         `json:"buyer,omitempty"`
+	Items     []ReservedItem `json:"items"`
+	ExpiresAt time.Time      `json:"expires_at"`
+}
+
+type ReservedItem struct {
+	WalletID string `json:"wallet_id"`
+	PolicyID string `json:"policy_id"`
+	AssetID  string `json:"asset_id"`
+	Quantity uint64 `json:"quantity"`
+	Lots     uint64 `json:"lots"`
+}
+
+// reservations is the in-process inventory ledger. It isn't persisted: after
+// a restart the wallet balances are all there is.
+type reservations struct {
+	mx   *sync.Mutex
+	ttl  time.Duration
+	held map[string]Reservation
+}
+
+func newReservations(ttl time.Duration) reservations {
+	return reservations{
+		mx:   &sync.Mutex{},
+		ttl:  ttl,
+		held: make(map[string]Reservation),
+	}
+}
+
+// reserve reserves items for the purchase id of buyer, replacing what it held
+// before. free[i] is the stock of items[i] above its buffer; it must cover
+// the item on top of what other purchases hold, and caps[i], the sale caps of
+// the item, its lots on top of what other purchases hold.
+func (r *reservations) reserve(id, buyer string, items []ReservedItem, free []uint64, caps []saleCap) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.prune()
+
+	for i, item := range items {
+		if r.reserved(item.WalletID, item.PolicyID, item.AssetID, id)+item.Quantity > free[i] {
+			return ErrInsufficientBalance
+		}
+
+		held, heldForBuyer := r.reservedLots(item.PolicyID, item.AssetID, buyer, id)
+		if err := caps[i].check(held, heldForBuyer, item.Lots); err != nil {
+			return err
+		}
+	}
+
+	r.held[id] = Reservation{
+		ID:        id,
+		Buyer:     buyer,
+		Items:     items,
+		ExpiresAt: time.Now().UTC().Add(r.ttl),
+	}
+
+	return nil
+}
+
+// release drops the reservation of the purchase id, if any.
+func (r *reservations) release(id string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	delete(r.held, id)
+}
+
+// Reserved returns the quantity of the asset in the wallet held by
+// outstanding reservations.
+func (r *reservations) Reserved(walletID string, asset config.Asset) uint64 {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.prune()
+
+	return r.reserved(walletID, asset.PolicyID, asset.AssetID, "")
+}
+
+// Held reports whether any outstanding reservation holds stock of the
+// wallet.
+func (r *reservations) Held(walletID string) bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.prune()
+
+	for _, reservation := range r.held {
+		for _, item := range reservation.Items {
+			if item.WalletID == walletID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (r *reservations) GetReservations() (reservations []Reservation) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.prune()
+
+	for _, reservation := range r.held {
+		reservations = append(reservations, reservation)
+	}
+
+	sort.Slice(reservations, func(i, j int) bool {
+		return reservations[i].ExpiresAt.Before(reservations[j].ExpiresAt)
+	})
+
+	return reservations
+}
+
+// reserved sums the reservations of the asset in the wallet, except the one
+// of the purchase except. r.mx must be held.
+func (r *reservations) reserved(walletID, policyID, assetID, except string) (quantity uint64) {
+	for id, reservation := range r.held {
+		if id == except {
+			continue
+		}
+
+		for _, item := range reservation.Items {
+			if item.WalletID == walletID && item.PolicyID == policyID && item.AssetID == assetID {
+				quantity += item.Quantity
+			}
+		}
+	}
+
+	return quantity
+}
+
+// ReservedLots returns the lots of the asset held by outstanding reservations
+// other than the one of the purchase except, in total and for buyer.
+func (r *reservations) ReservedLots(policyID, assetID, buyer, except string) (total, byBuyer uint64) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.prune()
+
+	return r.reservedLots(policyID, assetID, buyer, except)
+}
+
+// reservedLots is ReservedLots with r.mx held.
+func (r *reservations) reservedLots(policyID, assetID, buyer, except string) (total, byBuyer uint64) {
+	for id, reservation := range r.held {
+		if id == except {
+			continue
+		}
+
+		for _, item := range reservation.Items {
+			if item.PolicyID != policyID || item.AssetID != assetID {
+				continue
+			}
+
+			total += item.Lots
+			if buyer != "" && reservation.Buyer == buyer {
+				byBuyer += item.Lots
+			}
+		}
+	}
+
+	return total, byBuyer
+}
+
+// prune drops expired reservations. r.mx must be held.
+func (r *reservations) prune() {
+	now := time.Now()
+
+	for id, reservation := range r.held {
+		if now.After(reservation.ExpiresAt) {
+			delete(r.held, id)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------
+
+// reserveInventory checks the live balances of the order's wallets and the
+// sale caps of its items, and reserves its items for the purchase txID.
+func (t *TransactionRepo) reserveInventory(ctx context.Context, txID string, o order) error {
+	items := make([]ReservedItem, 0, len(o.items))
+	free := make([]uint64, 0, len(o.items))
+	caps := make([]saleCap, 0, len(o.items))
+
+	for _, item := range o.items {
+		stock, err := t.freeStock(ctx, item.wallet.ID, item.asset)
+		if err != nil {
+			return err
+		}
+
+		items = append(items, ReservedItem{
+			WalletID: item.wallet.ID,
+			PolicyID: item.asset.PolicyID,
+			AssetID:  item.asset.AssetID,
+			Quantity: item.asset.AssetQuantityWithDecimals,
+			Lots:     item.lots,
+		})
+		free = append(free, stock)
+		caps = append(caps, t.saleCap(item.asset, o.buyer))
+	}
+
+	return t.reservations.reserve(txID, o.buyer, items, free, caps)
+}
+
+func (t *TransactionRepo) GetReservations() []Reservation {
+	return t.reservations.GetReservations()
+}
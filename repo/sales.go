@@ -0,0 +1,399 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/address"
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+	"github.com/intellisoftalpin/cardano-wallet-backend/metadata"
+)
+
+// orderItem is one asset of an order. Its asset is priced at the buyer's
+// quote and scaled to the ordered lots, so price and quantity cover the whole
+// item.
+type orderItem struct {
+	wallet wallet
+	asset  config.Asset
+	lots   uint64
+}
+
+// order is a purchase request checked against the prices and sale rules of
+// its assets. The first item is the asset the purchase was made for, whose
+// wallet received the payment.
+type order struct {
+	request metadata.PurchaseRequest
+
+	items []orderItem
+	buyer string
+}
+
+// due returns the lovelace the buyer has to pay for the order.
+func (o order) due() (due uint64) {
+	for _, item := range o.items {
+		due += item.asset.PriceLovelace + item.asset.Deposit + item.asset.ProcessingFee
+	}
+
+	return due
+}
+
+// byWallet groups the order items by the wallet holding them, keeping the
+// order in which wallets first appear.
+func (o order) byWallet() (groups [][]orderItem) {
+	index := make(map[string]int)
+
+	for _, item := range o.items {
+		i, ok := index[item.wallet.ID]
+		if !ok {
+			i = len(groups)
+			index[item.wallet.ID] = i
+			groups = append(groups, nil)
+		}
+
+		groups[i] = append(groups[i], item)
+	}
+
+	return groups
+}
+
+// prepareOrder checks the purchase request in the metadata of tx, a payment
+// to the wallet of primary, and prices its items. The other items are sold by
+// the wallets selectHolding picks for the purchase.
+func (t *TransactionRepo) prepareOrder(ctx context.Context, primary holding, tx cwalletapi.Transaction) (o order, err error) {
+	o.request, err = metadata.Decode(tx.Metadata)
+	if err != nil {
+		return o, err
+	}
+
+	o.buyer = buyerKey(o.request.Address)
+
+	first := -1
+	for i, item := range o.request.Items {
+		if item.PolicyID == primary.asset.PolicyID && item.AssetID == primary.asset.AssetID {
+			first = i
+		}
+	}
+
+	if first < 0 {
+		return o, ErrWrongAsset
+	}
+
+	items := append([]metadata.Item{o.request.Items[first]}, o.request.Items[:first]...)
+	items = append(items, o.request.Items[first+1:]...)
+
+	var due uint64
+
+	for i, item := range items {
+		h := primary
+		if i > 0 {
+			if h, err = t.selectHolding(tx.ID, item.PolicyID, item.AssetID); err != nil {
+				return o, fmt.Errorf("%w: %s.%s is not for sale", ErrWrongAsset, item.PolicyID, item.AssetID)
+			}
+		}
+
+		itemWallet, itemAsset := h.wallet, h.asset
+
+		if err = t.checkPayoutAddress(ctx, itemAsset, o.request.Address); err != nil {
+			return o, err
+		}
+
+		itemAsset, err = t.quotedAsset(itemAsset, item.QuotedPrice)
+		if err != nil {
+			return o, err
+		}
+
+		if err = t.checkSaleRules(ctx, tx.ID, itemAsset, o.buyer, item.Quantity); err != nil {
+			return o, err
+		}
+
+		if inStock := stockLots(itemWallet, itemAsset); item.Quantity > inStock {
+			return o, fmt.Errorf("%w: %d lots of %s.%s asked, %d in stock", ErrInsufficientBalance, item.Quantity, item.PolicyID, item.AssetID, inStock)
+		}
+
+		if itemAsset, err = scaleAsset(itemAsset, item.Quantity); err != nil {
+			return o, err
+		}
+
+		var carry uint64
+		for _, cost := range []uint64{itemAsset.PriceLovelace, itemAsset.Deposit, itemAsset.ProcessingFee} {
+			var c uint64
+			due, c = bits.Add64(due, cost, 0)
+			carry |= c
+		}
+
+		if carry != 0 {
+			return o, fmt.Errorf("%w: the order's price overflows", ErrInvalidQuantity)
+		}
+
+		o.items = append(o.items, orderItem{
+			wallet: itemWallet,
+			asset:  itemAsset,
+			lots:   item.Quantity,
+		})
+	}
+
+	return o, nil
+}
+
+// stockLots returns the lots of the asset the wallet holds above its buffer,
+// reserved or not; reserveInventory accounts for reservations.
+func stockLots(w wallet, asset config.Asset) uint64 {
+	stock := w.stock(asset)
+	if stock <= asset.Buffer || asset.AssetQuantityWithDecimals == 0 {
+		return 0
+	}
+
+	return (stock - asset.Buffer) / asset.AssetQuantityWithDecimals
+}
+
+// scaleAsset prices the asset and scales its quantity to lots, rejecting
+// lot counts whose price or quantity doesn't fit in a uint64.
+func scaleAsset(asset config.Asset, lots uint64) (config.Asset, error) {
+	hiPrice, price := bits.Mul64(asset.PriceLovelace, lots)
+	hiQuantity, quantity := bits.Mul64(asset.AssetQuantityWithDecimals, lots)
+
+	if hiPrice != 0 || hiQuantity != 0 {
+		return asset, fmt.Errorf("%w: %d lots of %s.%s overflow", ErrInvalidQuantity, lots, asset.PolicyID, asset.AssetID)
+	}
+
+	asset.PriceLovelace = price
+	asset.AssetQuantityWithDecimals = quantity
+
+	return asset, nil
+}
+
+// checkSaleRules checks that lots more of the asset can be sold to buyer now
+// for the purchase txID, counting the lots held by other purchases'
+// reservations as sold.
+func (t *TransactionRepo) checkSaleRules(ctx context.Context, txID string, asset config.Asset, buyer string, lots uint64) error {
+	if err := t.checkCatalog(asset.PolicyID, asset.AssetID); err != nil {
+		return err
+	}
+
+	if err := t.checkSaleWindow(ctx, asset.Sale); err != nil {
+		return err
+	}
+
+	rules := asset.Sale
+
+	if rules.PerTxCap > 0 && lots > rules.PerTxCap {
+		return ErrTxLimit
+	}
+
+	held, heldForBuyer := t.reservations.ReservedLots(asset.PolicyID, asset.AssetID, buyer, txID)
+
+	return t.saleCap(asset, buyer).check(held, heldForBuyer, lots)
+}
+
+// saleCap is what the caps of a sale allow: sold and soldToBuyer are the
+// lots paid out in total and to the buyer.
+type saleCap struct {
+	total, perBuyer   uint64
+	sold, soldToBuyer uint64
+}
+
+func (t *TransactionRepo) saleCap(asset config.Asset, buyer string) (c saleCap) {
+	c.total, c.perBuyer = asset.Sale.TotalCap, asset.Sale.PerBuyerCap
+
+	if c.total > 0 || c.perBuyer > 0 {
+		c.sold, c.soldToBuyer = t.purchases.Sold(asset.PolicyID, asset.AssetID, buyer)
+	}
+
+	return c
+}
+
+// check checks that lots more can be sold on top of the lots held by
+// reservations, in total and for the buyer.
+func (c saleCap) check(held, heldForBuyer, lots uint64) error {
+	if c.total > 0 && c.sold+held+lots > c.total {
+		return ErrSoldOut
+	}
+
+	if c.perBuyer > 0 && c.soldToBuyer+heldForBuyer+lots > c.perBuyer {
+		return ErrBuyerLimit
+	}
+
+	return nil
+}
+
+func (t *TransactionRepo) checkSaleWindow(ctx context.Context, rules config.SaleRules) error {
+	return saleWindow(rules, time.Now(), func() (uint64, error) {
+		return t.networkEpoch(ctx)
+	})
+}
+
+// saleWindow checks that the sale is open at now and at the epoch, which is
+// only asked for when the sale has epoch bounds.
+func saleWindow(rules config.SaleRules, now time.Time, epoch func() (uint64, error)) error {
+	if !rules.StartTime.IsZero() && now.Before(rules.StartTime) {
+		return ErrSaleNotStarted
+	}
+
+	if !rules.EndTime.IsZero() && !now.Before(rules.EndTime) {
+		return ErrSaleEnded
+	}
+
+	if rules.StartEpoch == 0 && rules.EndEpoch == 0 {
+		return nil
+	}
+
+	current, err := epoch()
+	if err != nil {
+		return err
+	}
+
+	if current < rules.StartEpoch {
+		return ErrSaleNotStarted
+	}
+
+	if rules.EndEpoch > 0 && current > rules.EndEpoch {
+		return ErrSaleEnded
+	}
+
+	return nil
+}
+
+func (t *TransactionRepo) networkEpoch(ctx context.Context) (uint64, error) {
+	networkInfo, err := t.CardanoWalletApi.GetWalletNetworkInformation(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return networkInfo.NetworkTip.EpochNumber, nil
+}
+
+// saleState is what the sale allowances of a snapshot are worked out from,
+// read once per refresh rather than once per asset: the time, the epoch,
+// fetched at most once, and the lots sold per policyID.assetID.
+type saleState struct {
+	now   time.Time
+	epoch func() (uint64, error)
+	sold  map[string]uint64
+}
+
+func (t *TransactionRepo) readSaleState(ctx context.Context) saleState {
+	return saleState{
+		now: time.Now(),
+		epoch: sync.OnceValues(func() (uint64, error) {
+			return t.networkEpoch(ctx)
+		}),
+		sold: t.purchases.SoldTotals(),
+	}
+}
+
+// remainingLots returns how many lots of the asset the total cap still
+// allows on top of what is sold and reserved, and false when the sale is
+// closed or has no total cap.
+func (t *TransactionRepo) remainingLots(state saleState, asset config.Asset) (remaining uint64, limited bool, err error) {
+	if err = saleWindow(asset.Sale, state.now, state.epoch); err != nil {
+		return 0, true, err
+	}
+
+	if asset.Sale.TotalCap == 0 {
+		return 0, false, nil
+	}
+
+	total := state.sold[asset.PolicyID+"."+asset.AssetID]
+	held, _ := t.reservations.ReservedLots(asset.PolicyID, asset.AssetID, "", "")
+
+	total += held
+	if total >= asset.Sale.TotalCap {
+		return 0, true, nil
+	}
+
+	return asset.Sale.TotalCap - total, true, nil
+}
+
+// saleAllowance is what the sale rules of an asset still allow.
+type saleAllowance struct {
+	remaining uint64
+	limited   bool
+	err       error
+}
+
+func (t *TransactionRepo) saleAllowance(state saleState, asset config.Asset) (a saleAllowance) {
+	a.remaining, a.limited, a.err = t.remainingLots(state, asset)
+	return a
+}
+
+// apply caps the token's TotalQuantity at what the sale rules still allow.
+func (a saleAllowance) apply(token *cwalletapi.WalletAsset, asset config.Asset) {
+	if !a.limited {
+		return
+	}
+
+	if a.err != nil {
+		token.TotalQuantity = 0
+		return
+	}
+
+	remaining := a.remaining
+	token.RemainingLots = &remaining
+
+	if allowed := a.remaining * asset.AssetQuantityWithDecimals; allowed < token.TotalQuantity {
+		token.TotalQuantity = allowed
+	}
+}
+
+// checkPayoutAddress checks that tokens of the asset can be paid out to the
+// address on the wallet's network.
+func (t *TransactionRepo) checkPayoutAddress(ctx context.Context, asset config.Asset, payoutAddress string) error {
+	a, err := address.Decode(payoutAddress)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidAddress, err)
+	}
+
+	networkID, err := t.networkID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = a.CheckNetwork(networkID); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidAddress, err)
+	}
+
+	if a.Kind() == address.KindReward {
+		return fmt.Errorf("%w: reward addresses can't hold tokens", ErrInvalidAddress)
+	}
+
+	if a.IsScript() && !asset.AllowScriptPayout {
+		return fmt.Errorf("%w: script addresses are not allowed", ErrInvalidAddress)
+	}
+
+	return nil
+}
+
+// networkID returns the cardano-wallet network ID, fetched once.
+func (t *TransactionRepo) networkID(ctx context.Context) (string, error) {
+	t.networkMx.Lock()
+	defer t.networkMx.Unlock()
+
+	if t.network != "" {
+		return t.network, nil
+	}
+
+	networkInfo, err := t.CardanoWalletApi.GetWalletNetworkInformation(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	t.network = networkInfo.NetworkInfo.NetworkID
+
+	return t.network, nil
+}
+
+// buyerKey returns the stake credential of the payout address, or its payment
+// credential when it has no stake part. Addresses that don't decode are used
+// as they are.
+func buyerKey(payoutAddress string) string {
+	a, err := address.Decode(payoutAddress)
+	if err != nil {
+		return payoutAddress
+	}
+
+	return a.OwnerCredential()
+}
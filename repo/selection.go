@@ -0,0 +1,144 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+)
+
+// selectionTTL is how long the wallet chosen for a purchase is remembered.
+const selectionTTL = 2 * time.Hour
+
+type selections struct {
+	mx       *sync.Mutex
+	strategy string
+
+	chosen map[string]selection
+	next   map[string]uint64 // round-robin position per token
+}
+
+type selection struct {
+	walletID string
+	at       time.Time
+}
+
+func newSelections(strategy string) selections {
+	return selections{
+		mx:       &sync.Mutex{},
+		strategy: strategy,
+		chosen:   make(map[string]selection),
+		next:     make(map[string]uint64),
+	}
+}
+
+// selectHolding picks the wallet that sells the asset in the purchase txID,
+// skipping wallets that aren't ready or hold no more than their buffer and
+// reservations, and wallets the catalog doesn't back the token with. The
+// choice is remembered per purchase, so CheckTokenBalance and the later
+// CreateTransaction use the same wallet. An empty txID only looks at which
+// wallet would be chosen now.
+func (t *TransactionRepo) selectHolding(txID, policyID, assetID string) (h holding, err error) {
+	entry := t.catalog.Get(policyID, assetID)
+
+	var holdings []holding
+	for _, h := range t.wallets.GetWalletsByPolicyID(policyID, assetID) {
+		if entry.holds(h.wallet.Key) {
+			holdings = append(holdings, h)
+		}
+	}
+
+	if len(holdings) == 0 {
+		return h, fmt.Errorf("wallet not found")
+	}
+
+	s := &t.selections
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	key := txID + "/" + policyID + "." + assetID
+
+	if txID != "" {
+		if chosen, ok := s.chosen[key]; ok {
+			for _, h := range holdings {
+				if h.wallet.ID == chosen.walletID {
+					return h, nil
+				}
+			}
+		}
+	}
+
+	var eligible []holding
+	for _, h := range holdings {
+		reserved := t.reservations.Reserved(h.wallet.ID, h.asset)
+		if h.wallet.state.Status == "ready" && h.wallet.stock(h.asset) >= h.asset.Buffer+reserved+h.asset.AssetQuantityWithDecimals {
+			eligible = append(eligible, h)
+		}
+	}
+
+	if len(eligible) == 0 {
+		// nothing can sell it, let the usual checks tell why
+		return holdings[0], nil
+	}
+
+	h = eligible[0]
+
+	switch s.strategy {
+	case config.WalletSelectionMostStock:
+		for _, e := range eligible[1:] {
+			if e.wallet.stock(e.asset) > h.wallet.stock(h.asset) {
+				h = e
+			}
+		}
+	case config.WalletSelectionRoundRobin:
+		tokenID := policyID + "." + assetID
+		h = eligible[s.next[tokenID]%uint64(len(eligible))]
+		if txID != "" {
+			s.next[tokenID]++
+		}
+	}
+
+	if txID != "" {
+		now := time.Now()
+		for k, chosen := range s.chosen {
+			if now.Sub(chosen.at) > selectionTTL {
+				delete(s.chosen, k)
+			}
+		}
+
+		s.chosen[key] = selection{walletID: h.wallet.ID, at: now}
+	}
+
+	return h, nil
+}
+
+// decodePurchase decodes a purchase of the asset. When several wallets sell
+// the asset, the purchase belongs to the one it pays.
+func (t *TransactionRepo) decodePurchase(ctx context.Context, txCBOR, policyID, assetID string) (h holding, tx cwalletapi.Transaction, err error) {
+	holdings := t.wallets.GetWalletsByPolicyID(policyID, assetID)
+	if len(holdings) == 0 {
+		return h, tx, fmt.Errorf("wallet not found")
+	}
+
+	var first cwalletapi.Transaction
+
+	for i, h := range holdings {
+		tx, err = t.CardanoWalletApi.DecodeTransaction(ctx, h.wallet.ID, txCBOR)
+		if err != nil {
+			return h, tx, err
+		}
+
+		if len(holdings) == 1 || receivedLovelace(tx) > 0 {
+			return h, tx, nil
+		}
+
+		if i == 0 {
+			first = tx
+		}
+	}
+
+	return holdings[0], first, nil
+}
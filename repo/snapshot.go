@@ -0,0 +1,275 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+)
+
+// snapshotMetadataTTL is how long token metadata and wallet addresses are
+// reused before the poller fetches them again.
+const snapshotMetadataTTL = 10 * time.Minute
+
+// Snapshot is the catalog of tokens as of the last wallet poll. Storefront
+// calls are served from it without calling the wallet backend.
+type Snapshot struct {
+	Version uint64
+	TakenAt time.Time
+
+	tokens []snapshotToken
+}
+
+// snapshotToken is an asset of a wallet. Its token has every field set but
+// TotalQuantity, which is worked out when served, so reservations made since
+// the snapshot count.
+type snapshotToken struct {
+	walletID  string
+	walletKey string
+	asset     config.Asset
+	token     cwalletapi.WalletAsset
+
+	// stock is the quantity held above the buffer.
+	stock     uint64
+	allowance saleAllowance
+
+	// listed is set on the wallet that sells the asset at the moment.
+	listed   bool
+	priceErr error
+}
+
+// Age returns how old the snapshot is.
+func (s Snapshot) Age() time.Duration {
+	if s.TakenAt.IsZero() {
+		return 0
+	}
+
+	return time.Since(s.TakenAt)
+}
+
+type snapshots struct {
+	mx      *sync.RWMutex
+	current Snapshot
+
+	// metadata and addresses cache what the poller fetched
+	metadata  map[string]cachedToken
+	addresses map[string]cachedAddress
+}
+
+type cachedToken struct {
+	token     cwalletapi.WalletAsset
+	fetchedAt time.Time
+}
+
+type cachedAddress struct {
+	address   string
+	fetchedAt time.Time
+}
+
+func newSnapshots() snapshots {
+	return snapshots{
+		mx:        &sync.RWMutex{},
+		metadata:  make(map[string]cachedToken),
+		addresses: make(map[string]cachedAddress),
+	}
+}
+
+func (s *snapshots) Get() Snapshot {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	return s.current
+}
+
+func (s *snapshots) Set(snapshot Snapshot) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	snapshot.Version = s.current.Version + 1
+	s.current = snapshot
+}
+
+func (s *snapshots) getAddress(walletID string) (cached cachedAddress, ok bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	cached, ok = s.addresses[walletID]
+
+	return cached, ok
+}
+
+func (s *snapshots) setAddress(walletID string, address string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.addresses[walletID] = cachedAddress{address: address, fetchedAt: time.Now()}
+}
+
+func (s *snapshots) getToken(key string) (cached cachedToken, ok bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	cached, ok = s.metadata[key]
+
+	return cached, ok
+}
+
+func (s *snapshots) setToken(key string, token cwalletapi.WalletAsset) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.metadata[key] = cachedToken{token: token, fetchedAt: time.Now()}
+}
+
+// ----------------------------------------------------------------------
+
+// refreshSnapshot rebuilds the catalog snapshot from the wallet data of the
+// last poll.
+func (t *TransactionRepo) refreshSnapshot(ctx context.Context) {
+	snapshot := Snapshot{
+		TakenAt: time.Now().UTC(),
+	}
+
+	state := t.readSaleState(ctx)
+
+	for _, w := range t.wallets.GetOrderedWallets() {
+		if w.state.Status != "ready" {
+			continue
+		}
+
+		address, err := t.snapshotAddress(ctx, w.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error getting wallet address", "wallet_id", w.ID, "error", err)
+			continue
+		}
+
+		for _, a := range w.Assets {
+			token, err := t.snapshotTokenMetadata(ctx, w.ID, a)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error getting token", "token", a.PolicyID+"."+a.AssetID, "error", err)
+				continue
+			}
+
+			st := snapshotToken{
+				walletID:  w.ID,
+				walletKey: w.Key,
+				asset:     a,
+				allowance: t.saleAllowance(state, a),
+			}
+
+			if stock := w.stock(a); stock > a.Buffer {
+				st.stock = stock - a.Buffer
+			}
+
+			quote, err := t.prices.Price(a)
+			st.priceErr = err
+
+			token.Address = address
+			token.Price = quote.Lovelace
+			token.AssetUnit = a.AssetUnit
+			token.AssetQuantity = a.AssetQuantityWithDecimals
+			token.AssetDecimals = a.AssetDecimals
+			token.Fee = a.Fee
+			token.Deposit = a.Deposit
+			token.ProcessingFee = a.ProcessingFee
+			token.RewardAddress = a.RewardAddress
+			token.TotalQuantity = 0
+			st.token = token
+
+			h, err := t.selectHolding("", a.PolicyID, a.AssetID)
+			st.listed = err == nil && h.wallet.ID == w.ID
+
+			snapshot.tokens = append(snapshot.tokens, st)
+		}
+	}
+
+	t.snapshots.Set(snapshot)
+}
+
+func (t *TransactionRepo) snapshotAddress(ctx context.Context, walletID string) (string, error) {
+	cached, ok := t.snapshots.getAddress(walletID)
+	if ok && time.Since(cached.fetchedAt) < snapshotMetadataTTL {
+		return cached.address, nil
+	}
+
+	address, err := t.CardanoWalletApi.GetAddress(ctx, walletID)
+	if err != nil {
+		return "", err
+	}
+
+	t.snapshots.setAddress(walletID, address)
+
+	return address, nil
+}
+
+func (t *TransactionRepo) snapshotTokenMetadata(ctx context.Context, walletID string, asset config.Asset) (cwalletapi.WalletAsset, error) {
+	key := walletID + "/" + asset.PolicyID + "." + asset.AssetID
+
+	cached, ok := t.snapshots.getToken(key)
+	if ok && time.Since(cached.fetchedAt) < snapshotMetadataTTL {
+		return cached.token, nil
+	}
+
+	token, err := t.CardanoWalletApi.GetToken(ctx, walletID, asset.PolicyID, asset.AssetID)
+	if err != nil {
+		return token, err
+	}
+
+	t.snapshots.setToken(key, token)
+
+	return token, nil
+}
+
+// serve returns the token with the quantity for sale now: its stock less
+// outstanding reservations, capped by the sale rules.
+func (t *TransactionRepo) serve(st snapshotToken) cwalletapi.WalletAsset {
+	token := st.token
+
+	reserved := t.reservations.Reserved(st.walletID, st.asset)
+	if st.stock >= reserved+st.asset.AssetQuantityWithDecimals {
+		token.TotalQuantity = st.stock - reserved
+	}
+
+	st.allowance.apply(&token, st.asset)
+
+	return token
+}
+
+// token finds the catalog entry's token in the snapshot, among the wallets
+// backing it, preferring the wallet that sells it at the moment.
+func (s Snapshot) token(entry CatalogEntry) (st snapshotToken, err error) {
+	found := false
+
+	for _, candidate := range s.tokens {
+		if candidate.asset.PolicyID != entry.PolicyID || candidate.asset.AssetID != entry.AssetID {
+			continue
+		}
+
+		if !entry.holds(candidate.walletKey) {
+			continue
+		}
+
+		if candidate.listed {
+			return candidate, nil
+		}
+
+		if !found {
+			st, found = candidate, true
+		}
+	}
+
+	if !found {
+		return st, fmt.Errorf("token not found")
+	}
+
+	return st, nil
+}
+
+// GetSnapshot returns the catalog snapshot served at the moment.
+func (t *TransactionRepo) GetSnapshot() Snapshot {
+	return t.snapshots.Get()
+}
@@ -0,0 +1,101 @@
+package repo
+
+import (
+	"context"
+	"time"
+)
+
+// WalletStatus is the state of a configured wallet as of its last poll.
+type WalletStatus struct {
+	Key      string `json:"key"`
+	WalletID string `json:"wallet_id"`
+	Name     string `json:"name"`
+
+	Status   string  `json:"status"`
+	Progress float32 `json:"progress"`
+
+	LovelaceAvailable uint64 `json:"lovelace_available"`
+	LovelaceTotal     uint64 `json:"lovelace_total"`
+	LovelaceReward    uint64 `json:"lovelace_reward"`
+
+	Assets []AssetStatus `json:"assets"`
+
+	DelegationStatus string `json:"delegation_status"`
+	DelegationTarget string `json:"delegation_target,omitempty"`
+
+	// TipSlot is the wallet's tip and SlotLag how many slots it is behind the
+	// network tip.
+	TipSlot uint64 `json:"tip_slot"`
+	SlotLag uint64 `json:"slot_lag"`
+
+	PolledAt time.Time `json:"polled_at"`
+}
+
+// AssetStatus is the stock of a configured asset of a wallet.
+type AssetStatus struct {
+	PolicyID  string `json:"policy_id"`
+	AssetID   string `json:"asset_id"`
+	Available uint64 `json:"available"`
+	Buffer    uint64 `json:"buffer"`
+	Reserved  uint64 `json:"reserved"`
+
+	// ForSale is the quantity above the buffer and reservations.
+	ForSale uint64 `json:"for_sale"`
+}
+
+// GetWalletStatuses returns the status of the configured wallets, ordered
+// by priority and config key. Only the network tip is fetched; the rest is
+// from the last poll.
+func (t *TransactionRepo) GetWalletStatuses(ctx context.Context) (statuses []WalletStatus, err error) {
+	networkInfo, err := t.CardanoWalletApi.GetWalletNetworkInformation(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	networkSlot := networkInfo.NetworkTip.AbsoluteSlotNumber
+
+	for _, w := range t.wallets.GetOrderedWallets() {
+		status := WalletStatus{
+			Key:      w.Key,
+			WalletID: w.ID,
+			Name:     w.data.Name,
+
+			Status:   w.state.Status,
+			Progress: w.state.Progress.Quantity,
+
+			LovelaceAvailable: w.data.Balance.Available.Quantity,
+			LovelaceTotal:     w.data.Balance.Total.Quantity,
+			LovelaceReward:    w.data.Balance.Reward.Quantity,
+
+			DelegationStatus: w.data.Delegation.Active.Status,
+			DelegationTarget: w.data.Delegation.Active.Target,
+
+			TipSlot:  w.data.Tip.AbsoluteSlotNumber,
+			PolledAt: w.polledAt,
+		}
+
+		if networkSlot > status.TipSlot {
+			status.SlotLag = networkSlot - status.TipSlot
+		}
+
+		for _, asset := range w.Assets {
+			a := AssetStatus{
+				PolicyID:  asset.PolicyID,
+				AssetID:   asset.AssetID,
+				Available: w.stock(asset),
+				Buffer:    asset.Buffer,
+				Reserved:  t.reservations.Reserved(w.ID, asset),
+			}
+
+			if held := a.Buffer + a.Reserved; a.Available > held {
+				a.ForSale = a.Available - held
+			}
+
+			status.Assets = append(status.Assets, a)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
@@ -0,0 +1,288 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bykovme/goconfig"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/address"
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+)
+
+const sweepsFile = "sweeps.json"
+
+const (
+	SweepStatusSubmitted = "submitted"
+	SweepStatusFailed    = "failed"
+)
+
+// Sweep is a transfer of sale proceeds from a sale wallet to its cold
+// address.
+type Sweep struct {
+	ID        string `json:"id"`
+	WalletID  string `json:"wallet_id"`
+	Address   string `json:"address"`
+	Available uint64 `json:"available"`
+	Amount    uint64 `json:"amount"`
+	Status    string `json:"status"`
+	TxID      string `json:"tx_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type sweeps struct {
+	mx     *sync.RWMutex
+	file   string
+	sweeps map[string]Sweep
+
+	lastRun  map[string]time.Time     // last sweep check per wallet
+	payouts  map[string]int           // payouts being built per wallet
+	sweeping map[string]chan struct{} // sweeps being built per wallet, closed when done
+}
+
+type sweepsState struct {
+	Sweeps map[string]Sweep `json:"sweeps"`
+}
+
+func loadSweeps(stateDir string) sweeps {
+	file := filepath.Join(stateDir, sweepsFile)
+
+	state := sweepsState{}
+
+	if err := goconfig.LoadConfig(file, &state); err != nil {
+		slog.Info("No sweeps state found", "file", file)
+	}
+
+	if state.Sweeps == nil {
+		state.Sweeps = make(map[string]Sweep)
+	}
+
+	return sweeps{
+		mx:       &sync.RWMutex{},
+		file:     file,
+		sweeps:   state.Sweeps,
+		lastRun:  make(map[string]time.Time),
+		payouts:  make(map[string]int),
+		sweeping: make(map[string]chan struct{}),
+	}
+}
+
+func (s *sweeps) GetSweeps() (sweeps []Sweep) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	for _, sweep := range s.sweeps {
+		sweeps = append(sweeps, sweep)
+	}
+
+	sort.Slice(sweeps, func(i, j int) bool {
+		return sweeps[i].CreatedAt.Before(sweeps[j].CreatedAt)
+	})
+
+	return sweeps
+}
+
+func (s *sweeps) SetSweep(sweep Sweep) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.sweeps[sweep.ID] = sweep
+
+	if err := goconfig.SaveConfig(s.file, sweepsState{Sweeps: s.sweeps}); err != nil {
+		slog.Error("Error saving sweeps", "error", err)
+	}
+}
+
+// payoutStarted and payoutDone bracket building and submitting a payout from
+// the wallet, during which it isn't swept. payoutStarted waits for a sweep of
+// the wallet being built to finish, so the two don't spend the same outputs.
+func (s *sweeps) payoutStarted(ctx context.Context, walletID string) error {
+	for {
+		s.mx.Lock()
+		done, swept := s.sweeping[walletID]
+		if !swept {
+			s.payouts[walletID]++
+			s.mx.Unlock()
+
+			return nil
+		}
+		s.mx.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for the sweep of wallet %s: %w", walletID, ctx.Err())
+		}
+	}
+}
+
+func (s *sweeps) payoutDone(walletID string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.payouts[walletID]--
+}
+
+// claim reports whether the wallet's balance should be checked now, and if
+// so marks it checked and being swept until sweepDone, so a sweep is
+// started once however many polls race.
+func (s *sweeps) claim(walletID string, interval time.Duration) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, swept := s.sweeping[walletID]; swept || s.payouts[walletID] > 0 {
+		return false
+	}
+
+	if interval > 0 && time.Since(s.lastRun[walletID]) < interval {
+		return false
+	}
+
+	s.lastRun[walletID] = time.Now()
+	s.sweeping[walletID] = make(chan struct{})
+
+	return true
+}
+
+func (s *sweeps) sweepDone(walletID string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if done, ok := s.sweeping[walletID]; ok {
+		close(done)
+		delete(s.sweeping, walletID)
+	}
+}
+
+// ----------------------------------------------------------------------
+
+// sweepWallet sends the wallet's available lovelace above its low-water mark
+// to its cold address once it exceeds the high-water mark, less the fee and
+// the least lovelace the change output needs, so the wallet keeps its
+// low-water mark. Wallets with payouts being built, reserved stock or pending
+// transactions are left for a later poll.
+func (t *TransactionRepo) sweepWallet(ctx context.Context, walletID string) {
+	w, err := t.wallets.GetWallet(walletID)
+	if err != nil {
+		return
+	}
+
+	conf := w.Sweep
+	if conf.ColdAddress == "" || conf.HighWaterLovelace == 0 || w.state.Status != "ready" {
+		return
+	}
+
+	available := w.data.Balance.Available.Quantity
+	if available <= conf.HighWaterLovelace || available <= conf.LowWaterLovelace {
+		return
+	}
+
+	if !t.sweeps.claim(walletID, time.Duration(conf.IntervalSeconds)*time.Second) {
+		return
+	}
+	defer t.sweeps.sweepDone(walletID)
+
+	if t.reservations.Held(walletID) {
+		return
+	}
+
+	txs, err := t.CardanoWalletApi.ListTransactions(ctx, walletID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error listing wallet transactions", "wallet_id", walletID, "error", err)
+		return
+	}
+
+	for _, tx := range txs {
+		if tx.Status == "pending" && tx.Direction == "outgoing" {
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	sweep := Sweep{
+		ID:        fmt.Sprintf("%s-%d", walletID, now.UnixNano()),
+		WalletID:  walletID,
+		Address:   conf.ColdAddress,
+		Available: available,
+		Amount:    available - conf.LowWaterLovelace,
+		Status:    SweepStatusSubmitted,
+		CreatedAt: now,
+	}
+
+	if err = t.checkColdAddress(ctx, conf.ColdAddress); err != nil {
+		sweep.Status = SweepStatusFailed
+		sweep.Error = err.Error()
+		t.sweeps.SetSweep(sweep)
+
+		return
+	}
+
+	req := cwalletapi.CreateTransactionRequest{
+		Passphrase: w.Passphrase,
+		Payments: []cwalletapi.Payment{
+			{
+				Address: conf.ColdAddress,
+				Amount: cwalletapi.Quantity{
+					Quantity: sweep.Amount,
+					Unit:     "lovelace",
+				},
+			},
+		},
+		TimeToLive: cwalletapi.Quantity{
+			Quantity: t.payoutConfig.TTLSeconds,
+			Unit:     "second",
+		},
+	}
+
+	fee, err := t.CardanoWalletApi.EstimateFee(ctx, walletID, req)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error estimating sweep fee", "wallet_id", walletID, "error", err)
+		return
+	}
+
+	// the fee and the change output, which needs the least lovelace of any
+	// output, come out of the sweep, not out of the low-water mark
+	headroom := fee + config.MinDepositLovelace
+	if sweep.Amount < headroom+config.MinDepositLovelace {
+		return
+	}
+
+	sweep.Amount -= headroom
+	req.Payments[0].Amount.Quantity = sweep.Amount
+
+	_, newTx, err := t.CardanoWalletApi.CreateTransaction(ctx, walletID, req)
+	if err != nil {
+		sweep.Status = SweepStatusFailed
+		sweep.Error = err.Error()
+	} else {
+		sweep.TxID = newTx.ID
+	}
+
+	t.sweeps.SetSweep(sweep)
+}
+
+func (t *TransactionRepo) checkColdAddress(ctx context.Context, coldAddress string) error {
+	addr, err := address.Decode(coldAddress)
+	if err != nil {
+		return err
+	}
+
+	networkID, err := t.networkID(ctx)
+	if err != nil {
+		return err
+	}
+
+	return addr.CheckNetwork(networkID)
+}
+
+func (t *TransactionRepo) GetSweeps() []Sweep {
+	return t.sweeps.GetSweeps()
+}
@@ -1,24 +1,48 @@
 package repo
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/intellisoftalpin/cardano-wallet-backend/config"
 	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+	"github.com/intellisoftalpin/cardano-wallet-backend/events"
+	"github.com/intellisoftalpin/cardano-wallet-backend/price"
+	"github.com/intellisoftalpin/cardano-wallet-backend/secret"
+	"github.com/intellisoftalpin/cardano-wallet-backend/worker"
 )
 
 type TransactionRepo struct {
 	// config *config.Config
 	// wallets map[string]wallet
 
-	wallets          wallets
-	CardanoWalletApi *cwalletapi.CardanoWalletApi
+	wallets           wallets
+	refunds           refunds
+	purchases         purchases
+	refundConfig      config.RefundConfig
+	payoutConfig      config.PayoutConfig
+	prices            *price.Cache
+	selections        selections
+	reservations      reservations
+	alerts            alerts
+	sweeps            sweeps
+	catalog           catalog
+	restores          restores
+	snapshots         snapshots
+	workers           *worker.Supervisor
+	events            *events.Bus
+	walletConcurrency int
+	network           string
+	networkMx         *sync.Mutex
+	CardanoWalletApi  *cwalletapi.CardanoWalletApi
 }
 
-func NewTransactionRepo(config *config.Config) (t *TransactionRepo, err error) {
+func NewTransactionRepo(config *config.Config, secrets secret.Store) (t *TransactionRepo, err error) {
 	t = &TransactionRepo{
 		// config:  config,
 		// wallets: make(map[string]wallet),
@@ -26,9 +50,35 @@ func NewTransactionRepo(config *config.Config) (t *TransactionRepo, err error) {
 			mx:      &sync.RWMutex{},
 			wallets: make(map[string]wallet),
 		},
+		refunds:           loadRefunds(config.StateDir),
+		purchases:         loadPurchases(config.StateDir),
+		sweeps:            loadSweeps(config.StateDir),
+		catalog:           loadCatalog(config.StateDir),
+		restores:          newRestores(),
+		snapshots:         newSnapshots(),
+		workers:           worker.NewSupervisor(),
+		events:            events.NewBus(eventHistory),
+		walletConcurrency: int(config.WalletConcurrency),
+		refundConfig:      config.Refund,
+		payoutConfig:      config.Payout,
+		selections:        newSelections(config.WalletSelection),
+		reservations:      newReservations(time.Duration(config.ReservationTTLSeconds) * time.Second),
+		alerts:            newAlerts(config.Alerts),
+		networkMx:         &sync.Mutex{},
+	}
+
+	priceSource, err := price.NewSource(config.Price)
+	if err != nil {
+		return nil, err
 	}
 
-	t.CardanoWalletApi, err = cwalletapi.NewCardanoWalletApi(config)
+	t.prices = price.NewCache(priceSource,
+		time.Duration(config.Price.RefreshSeconds)*time.Second,
+		time.Duration(config.Price.MaxAgeSeconds)*time.Second,
+		time.Duration(config.Price.QuoteTTLSeconds)*time.Second,
+	)
+
+	t.CardanoWalletApi, err = cwalletapi.NewCardanoWalletApi(config, secrets)
 	if err != nil {
 		return nil, err
 	}
@@ -42,39 +92,18 @@ func NewTransactionRepo(config *config.Config) (t *TransactionRepo, err error) {
 		})
 	}
 
-	go func() {
-		timer := time.NewTicker(5 * time.Second)
-
-		for range timer.C {
-			// get wallet state from cardano-wallet
-
-			wallets := t.wallets.GetWallets()
-
-			for walletID := range wallets {
-				wallet, err := t.CardanoWalletApi.GetWalletData(walletID)
-				if err != nil {
-					t.wallets.SetWalletState(walletID, cwalletapi.WalletState{
-						Status: "syncing",
-					})
-					continue
-				}
-
-				t.wallets.SetWalletState(walletID, wallet.State)
-			}
-		}
-
-	}()
+	t.addWorkers(config, priceSource)
 
 	return t, nil
 }
 
-func (t *TransactionRepo) DecodeTransaction(txHash, policyID, assetID string) (tx cwalletapi.Transaction, err error) {
+func (t *TransactionRepo) DecodeTransaction(ctx context.Context, txHash, policyID, assetID string) (tx cwalletapi.Transaction, err error) {
 	wallet, _, err := t.wallets.GetWalletByPolicyID(policyID, assetID)
 	if err != nil {
 		return tx, err
 	}
 
-	tx, err = t.CardanoWalletApi.DecodeTransaction(wallet.ID, txHash)
+	tx, err = t.CardanoWalletApi.DecodeTransaction(ctx, wallet.ID, txHash)
 	if err != nil {
 		return tx, err
 	}
@@ -82,8 +111,8 @@ func (t *TransactionRepo) DecodeTransaction(txHash, policyID, assetID string) (t
 	return tx, nil
 }
 
-func (t *TransactionRepo) SubmitExternalTransaction(tx string) (txHash string, err error) {
-	txHash, err = t.CardanoWalletApi.SubmitExternalTransaction(tx)
+func (t *TransactionRepo) SubmitExternalTransaction(ctx context.Context, tx string) (txHash string, err error) {
+	txHash, err = t.CardanoWalletApi.SubmitExternalTransaction(ctx, tx)
 	if err != nil {
 		return txHash, err
 	}
@@ -91,258 +120,370 @@ func (t *TransactionRepo) SubmitExternalTransaction(tx string) (txHash string, e
 	return txHash, nil
 }
 
-func (t *TransactionRepo) GetTransaction(txHash, policyID, assetID string) (tx []byte, err error) {
-	wallet, _, err := t.wallets.GetWalletByPolicyID(policyID, assetID)
-	if err != nil {
-		return tx, err
+func (t *TransactionRepo) GetTransaction(ctx context.Context, txHash, policyID, assetID string) (tx []byte, err error) {
+	holdings := t.wallets.GetWalletsByPolicyID(policyID, assetID)
+	if len(holdings) == 0 {
+		return tx, fmt.Errorf("wallet not found")
 	}
 
-	tx, err = t.CardanoWalletApi.GetTransaction(wallet.ID, txHash)
-	if err != nil {
-		return tx, err
+	// the transaction may belong to any wallet selling the asset
+	for _, h := range holdings {
+		tx, err = t.CardanoWalletApi.GetTransaction(ctx, h.wallet.ID, txHash)
+		if err == nil {
+			return tx, nil
+		}
 	}
 
-	return tx, nil
+	return tx, err
 }
 
-func (t *TransactionRepo) CreateTransaction(txCBOR, policyID, assetID string) (rawTx []byte, txHash, addressTo, transferAmount, assetAmount, assetDecimals string, err error) {
-	wallet, asset, err := t.wallets.GetWalletByPolicyID(policyID, assetID)
+func (t *TransactionRepo) CreateTransaction(ctx context.Context, txCBOR, policyID, assetID string) (rawTx []byte, txHash, addressTo, transferAmount, assetAmount, assetDecimals string, err error) {
+	h, tx, err := t.decodePurchase(ctx, txCBOR, policyID, assetID)
 	if err != nil {
 		return rawTx, txHash, addressTo, transferAmount, assetAmount, assetDecimals, err
 	}
 
-	assetDecimals = fmt.Sprint(asset.AssetDecimals)
+	assetDecimals = fmt.Sprint(h.asset.AssetDecimals)
 
-	tx, err := t.CardanoWalletApi.DecodeTransaction(wallet.ID, txCBOR)
-	if err != nil {
-		return rawTx, txHash, addressTo, transferAmount, assetAmount, assetDecimals, err
+	rawTx, txHash, addressTo, transferAmount, assetAmount, err = t.createPayout(ctx, h, tx)
+	if err != nil && !errors.Is(err, ErrPurchaseProcessed) {
+		t.alertPayoutFailed(ctx, h.wallet.ID, tx, err)
+	}
+
+	if err != nil && isRefundable(err) {
+		t.refundPurchase(ctx, h.wallet, tx, err)
+	}
+
+	return rawTx, txHash, addressTo, transferAmount, assetAmount, assetDecimals, err
+}
+
+func (t *TransactionRepo) createPayout(ctx context.Context, h holding, tx cwalletapi.Transaction) (rawTx []byte, txHash, addressTo, transferAmount, assetAmount string, err error) {
+	purchase := Purchase{
+		TxID:     tx.ID,
+		WalletID: h.wallet.ID,
+		Received: receivedLovelace(tx),
+		Epoch:    t.currentEpoch(ctx),
+
+		CreatedAt: time.Now().UTC(),
 	}
 
-	req, err := t.ConstructCreateTransactionRequest(tx, wallet.Passphrase, asset)
+	// the wallets aren't swept while their payouts are built; the wallet paid
+	// is waited for before the purchase is claimed, so a caller giving up
+	// can send it again
+	if err = t.sweeps.payoutStarted(ctx, h.wallet.ID); err != nil {
+		return rawTx, txHash, addressTo, transferAmount, assetAmount, err
+	}
+	defer t.sweeps.payoutDone(h.wallet.ID)
+
+	// a purchase is paid out or refunded once, however often it is sent
+	if _, ok := t.refunds.GetRefund(tx.ID); ok || !t.purchases.claim(purchase) {
+		return rawTx, txHash, addressTo, transferAmount, assetAmount, ErrPurchaseProcessed
+	}
+
+	// the stock reserved by CheckTokenBalance is consumed either way
+	defer t.reservations.release(tx.ID)
+
+	defer func() {
+		purchase.Status = PurchaseStatusPaid
+		purchase.PayoutTxID = txHash
+		if err != nil {
+			purchase.Status = PurchaseStatusFailed
+			if len(purchase.Payouts) > 0 {
+				purchase.Status = PurchaseStatusPartial
+			}
+
+			purchase.Error = err.Error()
+		}
+
+		t.purchases.SetPurchase(purchase)
+	}()
+
+	order, err := t.prepareOrder(ctx, h, tx)
 	if err != nil {
-		return rawTx, txHash, addressTo, transferAmount, assetAmount, assetDecimals, err
+		return rawTx, txHash, addressTo, transferAmount, assetAmount, err
 	}
 
-	addressTo = req.Payments[0].Address
+	// the purchase is claimed, so the other wallets' sweeps are waited for
+	// even when the caller gives up
+	for _, item := range order.items[1:] {
+		if item.wallet.ID == h.wallet.ID {
+			continue
+		}
+
+		if err = t.sweeps.payoutStarted(context.WithoutCancel(ctx), item.wallet.ID); err != nil {
+			return rawTx, txHash, addressTo, transferAmount, assetAmount, err
+		}
+		defer t.sweeps.payoutDone(item.wallet.ID)
+	}
+
+	purchase.Address = order.request.Address
+	purchase.RefundAddress = refundAddress(tx)
+	purchase.Buyer = order.buyer
+
+	for _, item := range order.items {
+		purchase.Items = append(purchase.Items, PurchaseItem{
+			WalletID:      item.wallet.ID,
+			PolicyID:      item.asset.PolicyID,
+			AssetID:       item.asset.AssetID,
+			Lots:          item.lots,
+			AssetQuantity: item.asset.AssetQuantityWithDecimals,
+			Price:         item.asset.PriceLovelace,
+			Deposit:       item.asset.Deposit,
+			ProcessingFee: item.asset.ProcessingFee,
+		})
 
-	transferAmount = fmt.Sprintf("%d", req.Payments[0].Amount.Quantity)
-	assetAmount = fmt.Sprintf("%d", req.Payments[0].Assets[0].Quantity)
+		purchase.Price += item.asset.PriceLovelace
+		purchase.Deposit += item.asset.Deposit
+		purchase.ProcessingFee += item.asset.ProcessingFee
+	}
+
+	purchase.Surplus, purchase.SurplusPolicy = settleSurplus(purchase.Received, order.due(), order.items[0].asset)
 
-	rawTx, newTx, err := t.CardanoWalletApi.CreateTransaction(wallet.ID, req)
+	payouts, err := t.constructPayouts(tx, order)
 	if err != nil {
-		return rawTx, txHash, addressTo, transferAmount, assetAmount, assetDecimals, err
+		return rawTx, txHash, addressTo, transferAmount, assetAmount, err
 	}
 
-	txHash = newTx.ID
+	if err = t.reserveInventory(ctx, tx.ID, order); err != nil {
+		return rawTx, txHash, addressTo, transferAmount, assetAmount, err
+	}
 
-	return rawTx, txHash, addressTo, transferAmount, assetAmount, assetDecimals, err
+	addressTo = order.request.Address
+
+	transferAmount = fmt.Sprintf("%d", payouts[0].req.Payments[0].Amount.Quantity)
+	assetAmount = fmt.Sprintf("%d", payouts[0].req.Payments[0].Assets[0].Quantity)
+
+	for i, p := range payouts {
+		raw, newTx, err := t.CardanoWalletApi.CreateTransaction(ctx, p.wallet.ID, p.req)
+		if err != nil {
+			return rawTx, txHash, addressTo, transferAmount, assetAmount, err
+		}
+
+		if i == 0 {
+			rawTx = raw
+			txHash = newTx.ID
+		}
+
+		purchase.Payouts = append(purchase.Payouts, PurchasePayout{
+			WalletID: p.wallet.ID,
+			TxID:     newTx.ID,
+			Lovelace: p.req.Payments[0].Amount.Quantity,
+			Fee:      newTx.Fee.Quantity,
+			Status:   PayoutStatusPending,
+			Attempts: []PayoutAttempt{{
+				TxID:          newTx.ID,
+				Status:        newTx.Status,
+				SubmittedAt:   time.Now().UTC(),
+				ExpiresAtSlot: newTx.ExpiresAt.AbsoluteSlotNumber,
+			}},
+			Payments: p.req.Payments,
+			TTL:      p.req.TimeToLive.Quantity,
+		})
+	}
+
+	return rawTx, txHash, addressTo, transferAmount, assetAmount, err
 }
 
-func (t *TransactionRepo) CheckTokenBalance(txCBOR, policyID, assetID string) error {
-	wallet, walletAsset, err := t.wallets.GetWalletByPolicyID(policyID, assetID)
+func (t *TransactionRepo) CheckTokenBalance(ctx context.Context, txCBOR, policyID, assetID string) error {
+	h, tx, err := t.decodePurchase(ctx, txCBOR, policyID, assetID)
 	if err != nil {
 		return err
 	}
 
-	tx, err := t.CardanoWalletApi.DecodeTransaction(wallet.ID, txCBOR)
+	// sales halt while the price is stale or the sale rules don't allow it
+	order, err := t.prepareOrder(ctx, h, tx)
 	if err != nil {
 		return err
 	}
 
-	policyID = tx.Metadata["1002"].String
-	assetID = tx.Metadata["1003"].String
-	qty := tx.Metadata["1004"].Int
+	// holds the stock until CreateTransaction pays out the purchase
+	return t.reserveInventory(ctx, tx.ID, order)
+}
 
-	if policyID == "" || assetID == "" || qty == 0 {
-		return fmt.Errorf("invalid metadata")
+// quotedAsset returns the asset priced at the snapshot the buyer was quoted.
+// A zero quotedPrice means the buyer didn't say, and the current price applies.
+func (t *TransactionRepo) quotedAsset(asset config.Asset, quotedPrice uint64) (config.Asset, error) {
+	quote, err := t.prices.Quoted(asset, quotedPrice)
+	if err != nil {
+		return asset, err
 	}
 
-	walletData, err := t.CardanoWalletApi.GetWalletData(wallet.ID)
+	asset.PriceLovelace = quote.Lovelace
+
+	return asset, nil
+}
+
+// freeStock returns the live quantity of the asset the wallet holds above
+// walletAsset's buffer.
+func (t *TransactionRepo) freeStock(ctx context.Context, walletID string, walletAsset config.Asset) (uint64, error) {
+	walletData, err := t.CardanoWalletApi.GetWalletData(ctx, walletID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	for _, asset := range walletData.Assets.Available {
 		if asset.PolicyID == walletAsset.PolicyID &&
 			asset.AssetName == walletAsset.AssetID &&
-			asset.Quantity >= walletAsset.Buffer+walletAsset.AssetQuantityWithDecimals { // check if token balance is sufficient
-			return nil
+			asset.Quantity > walletAsset.Buffer {
+			return asset.Quantity - walletAsset.Buffer, nil
 		}
 	}
 
-	return fmt.Errorf("insufficient balance")
+	return 0, nil
 }
 
-func (t *TransactionRepo) GetAllTokens() (walletAssets []cwalletapi.WalletAsset, err error) {
-	wallets := t.wallets.GetWallets()
+// GetAllTokens returns the enabled, listed tokens in stock, in catalog
+// order.
+func (t *TransactionRepo) GetAllTokens() (tokens []CatalogToken, snapshot Snapshot, err error) {
+	snapshot = t.snapshots.Get()
 
-	for _, w := range wallets {
-		walletID := w.ID
-
-		if w.state.Status != "ready" {
+	for _, st := range snapshot.tokens {
+		entry := t.catalog.Get(st.asset.PolicyID, st.asset.AssetID)
+		if !entry.Enabled || entry.Hidden || !entry.holds(st.walletKey) {
 			continue
 		}
 
-		address, err := t.CardanoWalletApi.GetAddress(walletID)
-		if err != nil {
-			return nil, err
+		if !st.listed || st.priceErr != nil {
+			continue
 		}
 
-		for _, a := range w.Assets {
-			token, err := t.CardanoWalletApi.GetToken(walletID, a.PolicyID, a.AssetID)
-			if err != nil {
-				return nil, err
-			}
-
-			token.Address = address
-			token.Price = a.PriceLovelace
-			token.AssetUnit = a.AssetUnit
-			token.AssetQuantity = a.AssetQuantityWithDecimals
-			token.AssetDecimals = a.AssetDecimals
-			token.Fee = a.Fee
-			token.Deposit = a.Deposit
-			token.ProcessingFee = a.ProcessingFee
-			token.RewardAddress = a.RewardAddress
-
-			walletData, err := t.CardanoWalletApi.GetWalletData(walletID)
-			if err != nil {
-				return nil, err
-			}
-
-			for _, asset := range walletData.Assets.Available {
-				if asset.PolicyID == a.PolicyID &&
-					asset.AssetName == a.AssetID &&
-					asset.Quantity >= a.Buffer+a.AssetQuantityWithDecimals { // check if token balance is sufficient
-					token.TotalQuantity = asset.Quantity - a.Buffer
-				}
-			}
-
-			if token.TotalQuantity == 0 {
-				continue
-			}
-
-			walletAssets = append(walletAssets, token)
+		token := t.serve(st)
+		if token.TotalQuantity == 0 {
+			continue
 		}
-	}
 
-	return walletAssets, err
-}
-
-func (t *TransactionRepo) GetTokenData(tokenID string) (token cwalletapi.WalletAsset, err error) {
-	tID := strings.Split(tokenID, ".")
-	if len(tID) != 2 {
-		return token, fmt.Errorf("invalid tokenID")
+		tokens = append(tokens, entry.render(token))
 	}
 
-	policyID, assetID := tID[0], tID[1]
+	// ties keep the wallet order
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].Order < tokens[j].Order
+	})
 
-	wallet, asset, err := t.wallets.GetWalletByPolicyID(policyID, assetID)
-	if err != nil {
-		return token, err
-	}
+	return tokens, snapshot, nil
+}
 
-	walletID := wallet.ID
+// GetTokenData returns an enabled token, listed or hidden.
+func (t *TransactionRepo) GetTokenData(tokenID string) (token CatalogToken, snapshot Snapshot, err error) {
+	snapshot = t.snapshots.Get()
 
-	walletData, err := t.CardanoWalletApi.GetWalletData(walletID)
+	st, entry, err := t.catalogToken(snapshot, tokenID)
 	if err != nil {
-		return token, err
+		return token, snapshot, err
 	}
 
-	address, err := t.CardanoWalletApi.GetAddress(walletID)
-	if err != nil {
-		return token, err
-	}
-
-	for _, a := range walletData.Assets.Available {
-		if a.PolicyID == policyID && a.AssetName == assetID {
-			token, err := t.CardanoWalletApi.GetToken(walletID, a.PolicyID, a.AssetName)
-			if err != nil {
-				return token, err
-			}
+	return entry.render(t.serve(st)), snapshot, nil
+}
 
-			token.Address = address
-			token.Price = asset.PriceLovelace
+func (t *TransactionRepo) GetTokenPrice(tokenID string) (price uint64, snapshot Snapshot, err error) {
+	snapshot = t.snapshots.Get()
 
-			return token, nil
-		}
+	st, _, err := t.catalogToken(snapshot, tokenID)
+	if err != nil {
+		return price, snapshot, err
 	}
 
-	return token, err
+	return st.token.Price, snapshot, nil
 }
 
-func (t *TransactionRepo) GetTokenPrice(tokenID string) (price uint64, err error) {
+// catalogToken finds the enabled token in the snapshot.
+func (t *TransactionRepo) catalogToken(snapshot Snapshot, tokenID string) (st snapshotToken, entry CatalogEntry, err error) {
 	// parse tokenID. tokenID = "policyID.assetName"
 	tID := strings.Split(tokenID, ".")
 	if len(tID) != 2 {
-		return price, fmt.Errorf("invalid tokenID")
+		return st, entry, fmt.Errorf("invalid tokenID")
 	}
 
-	policyID, assetID := tID[0], tID[1]
+	entry = t.catalog.Get(tID[0], tID[1])
+	if !entry.Enabled {
+		return st, entry, ErrNotForSale
+	}
 
-	_, asset, err := t.wallets.GetWalletByPolicyID(policyID, assetID)
+	st, err = snapshot.token(entry)
 	if err != nil {
-		return price, err
+		return st, entry, err
 	}
 
-	return asset.PriceLovelace, err
+	if st.priceErr != nil {
+		return st, entry, st.priceErr
+	}
+
+	return st, entry, nil
 }
 
 // ----------------------------------------------------------------------
 
-func (c *TransactionRepo) ConstructCreateTransactionRequest(tx cwalletapi.Transaction, passphrase string, asset config.Asset) (req cwalletapi.CreateTransactionRequest, err error) {
-	address := tx.Metadata["1010"].String + tx.Metadata["1011"].String
-	policyID := tx.Metadata["1002"].String
-	assetID := tx.Metadata["1003"].String
-	qty := tx.Metadata["1004"].Int
-
-	if address == "" || policyID == "" || assetID == "" || qty == 0 {
-		return req, fmt.Errorf("invalid metadata")
-	}
+// payout is the transaction paying out the order items held by one wallet.
+type payout struct {
+	wallet wallet
+	req    cwalletapi.CreateTransactionRequest
+}
 
-	var totalOutputAmountQuantity uint64
+// constructPayouts builds one payout per wallet in the order, each with a
+// single output carrying all of that wallet's assets and their deposits. The
+// wallet that received the payment pays out first and settles the surplus.
+// A payout lives for the payout TTL of its wallet's first asset.
+func (c *TransactionRepo) constructPayouts(tx cwalletapi.Transaction, o order) (payouts []payout, err error) {
+	// only outputs paid to the wallet count, the rest is the buyer's change
+	received := receivedLovelace(tx)
 
-	for _, output := range tx.Outputs {
-		totalOutputAmountQuantity += output.Amount.Quantity
+	if received < o.due() {
+		return payouts, ErrInsufficientPayment
 	}
 
-	deposit := asset.Deposit
+	for _, items := range o.byWallet() {
+		payment := cwalletapi.Payment{
+			Address: o.request.Address,
+			Amount: cwalletapi.Quantity{
+				Unit: "lovelace",
+			},
+		}
 
-	if asset.PriceLovelace > totalOutputAmountQuantity-deposit-asset.ProcessingFee {
-		return req, fmt.Errorf("insufficient balance")
+		for _, item := range items {
+			payment.Amount.Quantity += item.asset.Deposit
+			payment.Assets = append(payment.Assets, cwalletapi.Asset{
+				PolicyID:  item.asset.PolicyID,
+				AssetName: item.asset.AssetID,
+				Quantity:  item.asset.AssetQuantityWithDecimals,
+			})
+		}
+
+		payouts = append(payouts, payout{
+			wallet: items[0].wallet,
+			req: cwalletapi.CreateTransactionRequest{
+				Passphrase: items[0].wallet.Passphrase,
+				Payments:   []cwalletapi.Payment{payment},
+				Withdrawal: "self",
+				TimeToLive: cwalletapi.Quantity{
+					Quantity: items[0].asset.PayoutTTLSeconds,
+					Unit:     "second",
+				},
+			},
+		})
 	}
 
-	finalQty := asset.AssetQuantityWithDecimals
+	primary := o.items[0].asset
+	surplus, policy := settleSurplus(received, o.due(), primary)
 
-	req = cwalletapi.CreateTransactionRequest{
-		Passphrase: passphrase,
-		Payments: []cwalletapi.Payment{
-			{
-				Address: address,
-				Amount: cwalletapi.Quantity{
-					Quantity: deposit,
-					Unit:     "lovelace",
-				},
-				Assets: []cwalletapi.Asset{
-					{
-						PolicyID:  policyID,
-						AssetName: assetID,
-						Quantity:  finalQty,
-					},
-				},
+	switch policy {
+	case config.OverpaymentReturn:
+		// the surplus rides along with the tokens
+		payouts[0].req.Payments[0].Amount.Quantity += surplus
+	case config.OverpaymentDonate:
+		payouts[0].req.Payments = append(payouts[0].req.Payments, cwalletapi.Payment{
+			Address: primary.DonationAddress,
+			Amount: cwalletapi.Quantity{
+				Quantity: surplus,
+				Unit:     "lovelace",
 			},
-		},
-		Withdrawal: "self",
-		TimeToLive: cwalletapi.Quantity{
-			Quantity: 3600, // 1 hour
-			Unit:     "second",
-		},
+		})
 	}
 
-	return req, nil
+	return payouts, nil
 }
 
-func (c *TransactionRepo) GetWalletNetworkInfo() (networkInfo cwalletapi.NetworkInfo, err error) {
-	networkInfo, err = c.CardanoWalletApi.GetWalletNetworkInformation()
+func (c *TransactionRepo) GetWalletNetworkInfo(ctx context.Context) (networkInfo cwalletapi.NetworkInfo, err error) {
+	networkInfo, err = c.CardanoWalletApi.GetWalletNetworkInformation(ctx)
 	if err != nil {
 		return networkInfo, err
 	}
@@ -350,10 +491,10 @@ func (c *TransactionRepo) GetWalletNetworkInfo() (networkInfo cwalletapi.Network
 	return networkInfo, err
 }
 
-func (c *TransactionRepo) GetWalletsState() (walletsState []cwalletapi.WalletState, err error) {
+func (c *TransactionRepo) GetWalletsState(ctx context.Context) (walletsState []cwalletapi.WalletState, err error) {
 	walletsState = make([]cwalletapi.WalletState, 0)
 
-	wallets, err := c.CardanoWalletApi.GetListWallets()
+	wallets, err := c.CardanoWalletApi.GetListWallets(ctx)
 	if err != nil {
 		return walletsState, err
 	}
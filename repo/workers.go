@@ -0,0 +1,101 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+	cwalletapi "github.com/intellisoftalpin/cardano-wallet-backend/cwallet-api"
+	"github.com/intellisoftalpin/cardano-wallet-backend/logging"
+	"github.com/intellisoftalpin/cardano-wallet-backend/price"
+	"github.com/intellisoftalpin/cardano-wallet-backend/worker"
+)
+
+// addWorkers registers the background jobs of the repo with its supervisor.
+func (t *TransactionRepo) addWorkers(conf *config.Config, priceSource price.Source) {
+	t.addWorker(conf, config.WorkerWallets, t.pollWallets)
+
+	t.addWorker(conf, config.WorkerPayouts, func(ctx context.Context) error {
+		t.trackPayouts(ctx)
+		t.confirmRefunds(ctx)
+		return nil
+	})
+
+	if reloader, ok := priceSource.(price.Reloader); ok {
+		t.addWorker(conf, config.WorkerPrices, func(ctx context.Context) error {
+			return reloader.Reload(ctx)
+		})
+	}
+
+	// delivers alerts until stopped, and again a second after a panic
+	t.workers.Add("alerts", time.Second, 0, t.alerts.notifier.Deliver)
+}
+
+// addWorker registers a job. Each run gets its own request ID, passed on to
+// the cardano-wallet calls it makes.
+func (t *TransactionRepo) addWorker(conf *config.Config, name string, job worker.Job) {
+	w := conf.Workers[name]
+
+	t.workers.Add(name,
+		time.Duration(w.IntervalSeconds)*time.Second,
+		time.Duration(w.JitterSeconds)*time.Second,
+		func(ctx context.Context) error {
+			return job(logging.WithRequestID(ctx, logging.NewRequestID()))
+		},
+	)
+}
+
+// Start runs the background workers until ctx is cancelled.
+func (t *TransactionRepo) Start(ctx context.Context) {
+	t.workers.Start(ctx)
+}
+
+// Wait blocks until the background workers stopped.
+func (t *TransactionRepo) Wait() {
+	t.workers.Wait()
+}
+
+func (t *TransactionRepo) GetWorkers() []worker.Status {
+	return t.workers.Status()
+}
+
+// ----------------------------------------------------------------------
+
+// pollWallets refreshes the state of every wallet from cardano-wallet, a few
+// at a time, then rebuilds the catalog snapshot.
+func (t *TransactionRepo) pollWallets(ctx context.Context) error {
+	var walletIDs []string
+	for walletID := range t.wallets.GetWallets() {
+		walletIDs = append(walletIDs, walletID)
+	}
+
+	err := worker.Parallel(ctx, t.walletConcurrency, walletIDs, t.pollWallet)
+
+	t.refreshSnapshot(ctx)
+
+	return err
+}
+
+func (t *TransactionRepo) pollWallet(ctx context.Context, walletID string) error {
+	before, _ := t.wallets.GetWallet(walletID)
+	defer func() {
+		after, _ := t.wallets.GetWallet(walletID)
+		t.publishWalletEvents(before, after)
+	}()
+
+	wallet, err := t.CardanoWalletApi.GetWalletData(ctx, walletID)
+	if err != nil {
+		t.wallets.SetWalletState(walletID, cwalletapi.WalletState{
+			Status: "syncing",
+		})
+		t.checkWalletAlerts(ctx, walletID)
+
+		return err
+	}
+
+	t.wallets.SetWalletData(walletID, wallet)
+	t.checkWalletAlerts(ctx, walletID)
+	t.sweepWallet(ctx, walletID)
+
+	return nil
+}
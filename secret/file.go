@@ -0,0 +1,195 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileStore keeps secrets in a JSON file, encrypted or not. The plain file
+// is meant for development.
+type FileStore struct {
+	mx   *sync.Mutex
+	path string
+
+	// gcm encrypts the file, nil for a plain file
+	gcm cipher.AEAD
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		mx:   &sync.Mutex{},
+		path: path,
+	}
+}
+
+// NewEncryptedFileStore returns a store whose file is encrypted with
+// AES-256-GCM under key.
+func NewEncryptedFileStore(path string, key []byte) (*FileStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStore{
+		mx:   &sync.Mutex{},
+		path: path,
+		gcm:  gcm,
+	}, nil
+}
+
+// LoadKey reads a 32 byte key, hex or base64 encoded, from the environment
+// variable keyEnv or else from keyFile.
+func LoadKey(keyEnv, keyFile string) ([]byte, error) {
+	encoded := os.Getenv(keyEnv)
+
+	if encoded == "" && keyFile != "" {
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading secrets key: %w", err)
+		}
+
+		encoded = string(b)
+	}
+
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, fmt.Errorf("no secrets key: set %s or a key file", keyEnv)
+	}
+
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(encoded)
+	}
+
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("the secrets key must be 32 bytes, hex or base64 encoded")
+	}
+
+	return key, nil
+}
+
+func (f *FileStore) Get(name string) (string, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	secrets, err := f.load()
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := secrets[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return value, nil
+}
+
+func (f *FileStore) Set(name, value string) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	secrets, err := f.load()
+	if err != nil {
+		return err
+	}
+
+	secrets[name] = value
+
+	return f.save(secrets)
+}
+
+// encryptedFile is the format of the encrypted file: the JSON of the secrets
+// sealed with a random nonce.
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func (f *FileStore) load() (secrets map[string]string, err error) {
+	secrets = make(map[string]string)
+
+	b, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if f.gcm != nil {
+		var file encryptedFile
+		if err = json.Unmarshal(b, &file); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.path, err)
+		}
+
+		if b, err = f.gcm.Open(nil, file.Nonce, file.Ciphertext, nil); err != nil {
+			return nil, fmt.Errorf("%s: decrypting: wrong key or corrupt file", f.path)
+		}
+	}
+
+	if err = json.Unmarshal(b, &secrets); err != nil {
+		return nil, fmt.Errorf("%s: %w", f.path, err)
+	}
+
+	return secrets, nil
+}
+
+// save writes the file readable by the owner only, replacing it at once so a
+// crash can't leave it half written.
+func (f *FileStore) save(secrets map[string]string) error {
+	b, err := json.MarshalIndent(secrets, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if f.gcm != nil {
+		nonce := make([]byte, f.gcm.NonceSize())
+		if _, err = rand.Read(nonce); err != nil {
+			return err
+		}
+
+		b, err = json.Marshal(encryptedFile{
+			Version:    1,
+			Nonce:      nonce,
+			Ciphertext: f.gcm.Seal(nil, nonce, b, nil),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,113 @@
+// Package secret keeps wallet mnemonics, spending passphrases and other
+// secrets out of the config and state files.
+//
+// Secrets are named by slash-separated paths, such as
+// "wallets/1/passphrase". Config values of the form "secret:NAME" refer to
+// a secret by name and are replaced by its value with Resolve.
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+)
+
+var ErrNotFound = errors.New("secret not found")
+
+// Store keeps secrets by name.
+type Store interface {
+	Get(name string) (string, error)
+	Set(name, value string) error
+}
+
+// NewStore builds the store selected in the secrets config. Relative files
+// are in the state dir.
+func NewStore(conf config.SecretsConfig, stateDir string) (Store, error) {
+	file := conf.File
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(stateDir, file)
+	}
+
+	switch conf.Backend {
+	case "", config.SecretsBackendFile:
+		return NewFileStore(file), nil
+	case config.SecretsBackendEncryptedFile:
+		key, err := LoadKey(conf.KeyEnv, conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewEncryptedFileStore(file, key)
+	case config.SecretsBackendVault:
+		return NewVaultStore(conf.VaultAddress, conf.VaultMount, conf.VaultPath, conf.VaultTokenEnv)
+	}
+
+	return nil, fmt.Errorf("unknown secrets backend: %s", conf.Backend)
+}
+
+// Ref returns the config value that refers to the secret.
+func Ref(name string) string {
+	return config.SecretRefPrefix + name
+}
+
+// Lookup returns the value, or the secret it refers to.
+func Lookup(store Store, value string) (string, error) {
+	if !config.IsSecretRef(value) {
+		return value, nil
+	}
+
+	name := strings.TrimPrefix(value, config.SecretRefPrefix)
+
+	secret, err := store.Get(name)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+
+	return secret, nil
+}
+
+// Resolve replaces the references to secrets in the config by their values.
+func Resolve(store Store, conf *config.Config) error {
+	for key, w := range conf.Wallets {
+		mnemonic, err := Lookup(store, w.Mnemonic)
+		if err != nil {
+			return fmt.Errorf("wallets.%s.mnemonic_sentence: %w", key, err)
+		}
+
+		w.Mnemonic = mnemonic
+		conf.Wallets[key] = w
+	}
+
+	for i, w := range conf.Alerts.Webhooks {
+		secret, err := Lookup(store, w.Secret)
+		if err != nil {
+			return fmt.Errorf("alerts.webhooks[%d].secret: %w", i, err)
+		}
+
+		conf.Alerts.Webhooks[i].Secret = secret
+	}
+
+	token, err := Lookup(store, conf.Admin.Token)
+	if err != nil {
+		return fmt.Errorf("admin.token: %w", err)
+	}
+
+	conf.Admin.Token = token
+
+	return nil
+}
+
+// WalletPassphrase is the name of the spending passphrase of the wallet with
+// the config key.
+func WalletPassphrase(key string) string {
+	return "wallets/" + key + "/passphrase"
+}
+
+// WalletMnemonic is the name of the mnemonic of the wallet with the config
+// key.
+func WalletMnemonic(key string) string {
+	return "wallets/" + key + "/mnemonic"
+}
@@ -0,0 +1,122 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultStore keeps secrets in a Vault KV version 2 engine, one entry per
+// secret under path, with the value in its "value" field. Anything serving
+// the same HTTP API works, such as a Vault dev server.
+type VaultStore struct {
+	address string
+	mount   string
+	path    string
+	token   string
+
+	client *http.Client
+}
+
+// NewVaultStore returns a store reading its token from the environment
+// variable tokenEnv.
+func NewVaultStore(address, mount, path, tokenEnv string) (*VaultStore, error) {
+	token := os.Getenv(tokenEnv)
+	if token == "" {
+		return nil, fmt.Errorf("no vault token: set %s", tokenEnv)
+	}
+
+	return &VaultStore{
+		address: strings.TrimSuffix(address, "/"),
+		mount:   strings.Trim(mount, "/"),
+		path:    strings.Trim(path, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type vaultData struct {
+	Data map[string]string `json:"data"`
+}
+
+type vaultResponse struct {
+	Data vaultData `json:"data"`
+}
+
+func (v *VaultStore) url(name string) string {
+	return v.address + "/v1/" + v.mount + "/data/" + v.path + "/" + strings.Trim(name, "/")
+}
+
+func (v *VaultStore) Get(name string) (string, error) {
+	b, status, err := v.do(http.MethodGet, name, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if status == http.StatusNotFound {
+		return "", ErrNotFound
+	}
+
+	if status != http.StatusOK {
+		return "", fmt.Errorf("vault: %d - %s", status, string(b))
+	}
+
+	var resp vaultResponse
+	if err = json.Unmarshal(b, &resp); err != nil {
+		return "", fmt.Errorf("vault: %w", err)
+	}
+
+	value, ok := resp.Data.Data["value"]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return value, nil
+}
+
+func (v *VaultStore) Set(name, value string) error {
+	body, err := json.Marshal(vaultData{Data: map[string]string{"value": value}})
+	if err != nil {
+		return err
+	}
+
+	b, status, err := v.do(http.MethodPost, name, body)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK && status != http.StatusNoContent {
+		return fmt.Errorf("vault: %d - %s", status, string(b))
+	}
+
+	return nil
+}
+
+func (v *VaultStore) do(method, name string, body []byte) (b []byte, status int, err error) {
+	req, err := http.NewRequest(method, v.url(name), bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req.Header.Set("X-Vault-Token", v.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if b, err = io.ReadAll(resp.Body); err != nil {
+		return nil, 0, err
+	}
+
+	return b, resp.StatusCode, nil
+}
@@ -0,0 +1,178 @@
+package secret
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testVaultToken = "test-token"
+
+// fakeVault serves a KV version 2 engine mounted at "secret", keeping the
+// data of each entry by its path under the mount.
+type fakeVault struct {
+	mx      sync.Mutex
+	entries map[string]map[string]string
+
+	// status, when set, is returned for every request instead.
+	status int
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != testVaultToken {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if f.status != 0 {
+		http.Error(w, `{"errors":["internal error"]}`, f.status)
+		return
+	}
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/data/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := f.entries[path]
+		if !ok {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(vaultResponse{Data: vaultData{Data: data}})
+	case http.MethodPost:
+		var body vaultData
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		f.entries[path] = body.Data
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeVault) get(path string) map[string]string {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	return f.entries[path]
+}
+
+func (f *fakeVault) set(path string, data map[string]string) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	f.entries[path] = data
+}
+
+func (f *fakeVault) fail(status int) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	f.status = status
+}
+
+func newTestVault(t *testing.T, token string) (*VaultStore, *fakeVault) {
+	t.Helper()
+
+	fake := &fakeVault{entries: make(map[string]map[string]string)}
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	t.Setenv("TEST_VAULT_TOKEN", token)
+
+	store, err := NewVaultStore(server.URL+"/", "/secret/", "cardano-wallet-backend", "TEST_VAULT_TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store, fake
+}
+
+func TestVaultStoreSetGet(t *testing.T) {
+	store, fake := newTestVault(t, testVaultToken)
+
+	if err := store.Set("wallets/1/passphrase", "correct horse"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	if got := fake.get("cardano-wallet-backend/wallets/1/passphrase")["value"]; got != "correct horse" {
+		t.Fatalf("stored value = %q, want %q", got, "correct horse")
+	}
+
+	value, err := store.Get("wallets/1/passphrase")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if value != "correct horse" {
+		t.Fatalf("Get = %q, want %q", value, "correct horse")
+	}
+}
+
+func TestVaultStoreNotFound(t *testing.T) {
+	store, fake := newTestVault(t, testVaultToken)
+
+	if _, err := store.Get("wallets/1/passphrase"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get of a missing entry: err = %v, want ErrNotFound", err)
+	}
+
+	// an entry without a value field
+	fake.set("cardano-wallet-backend/wallets/2/passphrase", map[string]string{"other": "x"})
+
+	if _, err := store.Get("wallets/2/passphrase"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get of an entry without a value: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestVaultStoreErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "forbidden", token: "wrong-token"},
+		{name: "server error", token: testVaultToken, status: http.StatusInternalServerError},
+		{name: "sealed", token: testVaultToken, status: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, fake := newTestVault(t, tt.token)
+			fake.set("cardano-wallet-backend/wallets/1/passphrase", map[string]string{"value": "x"})
+			fake.fail(tt.status)
+
+			_, err := store.Get("wallets/1/passphrase")
+			if err == nil || errors.Is(err, ErrNotFound) {
+				t.Errorf("Get: err = %v, want a vault error", err)
+			}
+
+			if err = store.Set("wallets/1/passphrase", "y"); err == nil {
+				t.Errorf("Set: err = nil, want a vault error")
+			}
+		})
+	}
+}
+
+func TestNewVaultStoreWithoutToken(t *testing.T) {
+	t.Setenv("TEST_VAULT_TOKEN", "")
+
+	if _, err := NewVaultStore("http://127.0.0.1:8200", "secret", "cardano-wallet-backend", "TEST_VAULT_TOKEN"); err == nil {
+		t.Fatal("NewVaultStore without a token: err = nil")
+	}
+}
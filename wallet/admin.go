@@ -0,0 +1,394 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/events"
+	"github.com/intellisoftalpin/cardano-wallet-backend/repo"
+)
+
+// AdminServer serves operator RPCs that aren't part of the public wallet
+// proto. Requests and responses are google.protobuf.Struct messages, so the
+// service needs no generated code on either side.
+type AdminServer struct {
+	TransactionRepo *repo.TransactionRepo
+}
+
+func NewAdminServer(transactionRepo *repo.TransactionRepo) *AdminServer {
+	return &AdminServer{
+		TransactionRepo: transactionRepo,
+	}
+}
+
+func RegisterAdminServer(s *grpc.Server, srv *AdminServer) {
+	s.RegisterService(&adminServiceDesc, srv)
+}
+
+const adminServiceName = "wallet.Admin"
+
+var adminServiceDesc = grpc.ServiceDesc{
+	ServiceName: adminServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		adminMethod("ListPurchases", (*AdminServer).ListPurchases),
+		adminMethod("GetPurchase", (*AdminServer).GetPurchase),
+		adminMethod("ListRefunds", (*AdminServer).ListRefunds),
+		adminMethod("ApproveRefund", (*AdminServer).ApproveRefund),
+		adminMethod("RejectRefund", (*AdminServer).RejectRefund),
+		adminMethod("ListReservations", (*AdminServer).ListReservations),
+		adminMethod("ListSweeps", (*AdminServer).ListSweeps),
+		adminMethod("GetReport", (*AdminServer).GetReport),
+		adminMethod("ExportReportCSV", (*AdminServer).ExportReportCSV),
+		adminMethod("GetDiagnostics", (*AdminServer).GetDiagnostics),
+		adminMethod("GetWalletStatus", (*AdminServer).GetWalletStatus),
+		adminMethod("ListCatalog", (*AdminServer).ListCatalog),
+		adminMethod("SetCatalogEntry", (*AdminServer).SetCatalogEntry),
+		adminMethod("DeleteCatalogEntry", (*AdminServer).DeleteCatalogEntry),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchWallets",
+			Handler:       watchWalletsHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "wallet/admin.go",
+}
+
+// ----------------------------------------------------------------------
+
+func (s *AdminServer) ListPurchases(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	return toStruct(map[string]interface{}{
+		"purchases": s.TransactionRepo.GetPurchases(),
+	})
+}
+
+// GetPurchase returns a purchase with the status, depth and attempts of its
+// payouts.
+func (s *AdminServer) GetPurchase(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	purchaseTxID, err := stringField(in, "purchase_tx_id")
+	if err != nil {
+		return nil, err
+	}
+
+	purchase, err := s.TransactionRepo.GetPurchase(purchaseTxID)
+	if err != nil {
+		return nil, err
+	}
+
+	return toStruct(purchase)
+}
+
+func (s *AdminServer) ListRefunds(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	return toStruct(map[string]interface{}{
+		"refunds": s.TransactionRepo.GetRefunds(),
+	})
+}
+
+func (s *AdminServer) ApproveRefund(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	id, err := refundIDField(in)
+	if err != nil {
+		return nil, err
+	}
+
+	refund, err := s.TransactionRepo.ApproveRefund(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return toStruct(refund)
+}
+
+func (s *AdminServer) RejectRefund(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	id, err := refundIDField(in)
+	if err != nil {
+		return nil, err
+	}
+
+	refund, err := s.TransactionRepo.RejectRefund(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return toStruct(refund)
+}
+
+func (s *AdminServer) ListReservations(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	return toStruct(map[string]interface{}{
+		"reservations": s.TransactionRepo.GetReservations(),
+	})
+}
+
+func (s *AdminServer) ListSweeps(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	return toStruct(map[string]interface{}{
+		"sweeps": s.TransactionRepo.GetSweeps(),
+	})
+}
+
+// GetReport returns the accounting report grouped by "group_by" (day, epoch
+// or range) over the optional RFC 3339 "from" and "to" times.
+func (s *AdminServer) GetReport(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	rows, err := s.report(in)
+	if err != nil {
+		return nil, err
+	}
+
+	return toStruct(map[string]interface{}{
+		"rows": rows,
+	})
+}
+
+// ExportReportCSV returns the report of GetReport as CSV text.
+func (s *AdminServer) ExportReportCSV(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	rows, err := s.report(in)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	if err = repo.WriteReportCSV(&b, rows); err != nil {
+		return nil, err
+	}
+
+	return toStruct(map[string]interface{}{
+		"csv": b.String(),
+	})
+}
+
+func (s *AdminServer) ListCatalog(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	return toStruct(map[string]interface{}{
+		"entries": s.TransactionRepo.GetCatalog(),
+	})
+}
+
+// SetCatalogEntry replaces the catalog entry of a token with the request,
+// which has the fields of repo.CatalogEntry. A missing enabled means true.
+func (s *AdminServer) SetCatalogEntry(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	if _, err := stringField(in, "policy_id"); err != nil {
+		return nil, err
+	}
+
+	if _, err := stringField(in, "asset_id"); err != nil {
+		return nil, err
+	}
+
+	b, err := in.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	entry := repo.CatalogEntry{Enabled: true}
+	if err = json.Unmarshal(b, &entry); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	entry, err = s.TransactionRepo.SetCatalogEntry(entry)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return toStruct(map[string]interface{}{
+		"entry": entry,
+	})
+}
+
+func (s *AdminServer) DeleteCatalogEntry(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	tokenID, err := stringField(in, "token_id")
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.TransactionRepo.DeleteCatalogEntry(tokenID); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	return toStruct(map[string]interface{}{
+		"deleted": tokenID,
+	})
+}
+
+// GetWalletStatus reports the configured wallets: sync, balances, stock
+// against buffers, delegation and how far they lag behind the network.
+func (s *AdminServer) GetWalletStatus(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	statuses, err := s.TransactionRepo.GetWalletStatuses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return toStruct(map[string]interface{}{
+		"wallets": statuses,
+	})
+}
+
+// GetDiagnostics reports the background workers and the catalog snapshot.
+func (s *AdminServer) GetDiagnostics(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	snapshot := s.TransactionRepo.GetSnapshot()
+
+	return toStruct(map[string]interface{}{
+		"workers": s.TransactionRepo.GetWorkers(),
+		"snapshot": map[string]interface{}{
+			"version":  snapshot.Version,
+			"taken_at": snapshot.TakenAt,
+			"age":      snapshot.Age().String(),
+		},
+	})
+}
+
+func (s *AdminServer) report(in *structpb.Struct) ([]repo.ReportRow, error) {
+	groupBy, err := stringField(in, "group_by")
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := timeField(in, "from")
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := timeField(in, "to")
+	if err != nil {
+		return nil, err
+	}
+
+	return s.TransactionRepo.GetReport(groupBy, from, to)
+}
+
+// WatchWallets streams wallet events. A client that reconnects sends the
+// epoch and sequence of the last event it received as "after_epoch" and
+// "after_sequence" to resume without missing events; without them only new
+// events are sent. Events from before a restart can't be resumed from.
+func (s *AdminServer) WatchWallets(in *structpb.Struct, stream grpc.ServerStream) error {
+	epoch := in.GetFields()["after_epoch"].GetStringValue()
+	after := uint64(in.GetFields()["after_sequence"].GetNumberValue())
+
+	backlog, ch, cancel, err := s.TransactionRepo.WatchEvents(epoch, after)
+	if err != nil {
+		return status.Error(codes.OutOfRange, err.Error())
+	}
+	defer cancel()
+
+	for _, event := range backlog {
+		if err = sendEvent(stream, event); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-ch:
+			if !ok {
+				return status.Error(codes.Unavailable, "watcher fell behind, resume from the last epoch and sequence received")
+			}
+
+			if err = sendEvent(stream, event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func sendEvent(stream grpc.ServerStream, event events.Event) error {
+	out, err := toStruct(event)
+	if err != nil {
+		return err
+	}
+
+	return stream.SendMsg(out)
+}
+
+func watchWalletsHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := new(structpb.Struct)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+
+	return srv.(*AdminServer).WatchWallets(in, stream)
+}
+
+// ----------------------------------------------------------------------
+
+func adminMethod(name string, fn func(*AdminServer, context.Context, *structpb.Struct) (*structpb.Struct, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(structpb.Struct)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+
+			if interceptor == nil {
+				return fn(srv.(*AdminServer), ctx, in)
+			}
+
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + adminServiceName + "/" + name,
+			}
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return fn(srv.(*AdminServer), ctx, req.(*structpb.Struct))
+			}
+
+			return interceptor(ctx, in, info, handler)
+		},
+	}
+}
+
+// toStruct converts v to a Struct through its JSON encoding.
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]interface{})
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	return structpb.NewStruct(m)
+}
+
+func stringField(in *structpb.Struct, name string) (string, error) {
+	value := in.GetFields()[name].GetStringValue()
+	if value == "" {
+		return "", status.Errorf(codes.InvalidArgument, "%s is required", name)
+	}
+
+	return value, nil
+}
+
+// refundIDField returns the refund_id field, or else purchase_tx_id, the ID
+// of a refund of a whole purchase.
+func refundIDField(in *structpb.Struct) (string, error) {
+	if id := in.GetFields()["refund_id"].GetStringValue(); id != "" {
+		return id, nil
+	}
+
+	return stringField(in, "purchase_tx_id")
+}
+
+// timeField parses an optional RFC 3339 field, returning the zero time when
+// it is missing.
+func timeField(in *structpb.Struct, name string) (t time.Time, err error) {
+	value := in.GetFields()[name].GetStringValue()
+	if value == "" {
+		return t, nil
+	}
+
+	if t, err = time.Parse(time.RFC3339, value); err != nil {
+		return t, status.Errorf(codes.InvalidArgument, "%s: %s", name, err)
+	}
+
+	return t, nil
+}
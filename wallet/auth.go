@@ -0,0 +1,134 @@
+package wallet
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/config"
+)
+
+// NewAdminGRPCServer returns the server of the admin listener with the admin
+// service registered. Callers must present the configured token as
+// "authorization: Bearer TOKEN", or a client certificate signed by the
+// configured CA.
+func NewAdminGRPCServer(conf config.AdminConfig, srv *AdminServer) (*grpc.Server, error) {
+	if conf.Token == "" && conf.ClientCAFile == "" {
+		return nil, fmt.Errorf("admin is enabled without a token or client CA")
+	}
+
+	auth := adminAuth{
+		token:       conf.Token,
+		clientCerts: conf.ClientCAFile != "",
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(UnaryInterceptor, auth.unary),
+		grpc.ChainStreamInterceptor(StreamInterceptor, auth.stream),
+	}
+
+	if conf.CertFile != "" {
+		creds, err := adminCredentials(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	s := grpc.NewServer(opts...)
+	RegisterAdminServer(s, srv)
+
+	return s, nil
+}
+
+// adminCredentials serves the admin listener over TLS, verifying client
+// certificates against the client CA when one is set. Without a token to
+// fall back on, a client certificate is required to connect.
+func adminCredentials(conf config.AdminConfig) (credentials.TransportCredentials, error) {
+	certificate, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("admin certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if conf.ClientCAFile != "" {
+		ca, err := os.ReadFile(conf.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("admin client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("admin client CA: no certificates in %s", conf.ClientCAFile)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if conf.Token != "" {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+type adminAuth struct {
+	token       string
+	clientCerts bool
+}
+
+func (a adminAuth) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (a adminAuth) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.authorize(ss.Context()); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
+
+// authorize accepts the caller with the bearer token or a verified client
+// certificate.
+func (a adminAuth) authorize(ctx context.Context) error {
+	if a.token != "" {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, value := range md.Get("authorization") {
+			token, ok := strings.CutPrefix(value, "Bearer ")
+			if ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1 {
+				return nil
+			}
+		}
+	}
+
+	if a.clientCerts {
+		if p, ok := peer.FromContext(ctx); ok {
+			if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+				return nil
+			}
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "admin credentials required")
+}
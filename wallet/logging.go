@@ -0,0 +1,84 @@
+package wallet
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/intellisoftalpin/cardano-wallet-backend/logging"
+)
+
+// UnaryInterceptor gives each RPC a request ID, the caller's x-request-id or
+// a new one, returns it in the x-request-id response header and logs the
+// RPC once it is done.
+func UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx = withRequestID(ctx)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(logging.RequestIDHeader, logging.RequestID(ctx)))
+
+	start := time.Now()
+
+	resp, err := handler(ctx, req)
+
+	logRPC(ctx, info.FullMethod, start, err)
+
+	return resp, err
+}
+
+// StreamInterceptor is UnaryInterceptor for streaming RPCs.
+func StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := withRequestID(ss.Context())
+	_ = ss.SetHeader(metadata.Pairs(logging.RequestIDHeader, logging.RequestID(ctx)))
+
+	start := time.Now()
+
+	err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+
+	logRPC(ctx, info.FullMethod, start, err)
+
+	return err
+}
+
+func withRequestID(ctx context.Context) context.Context {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(logging.RequestIDHeader); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+
+	if id == "" {
+		id = logging.NewRequestID()
+	}
+
+	return logging.WithRequestID(ctx, id)
+}
+
+func logRPC(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+	}
+
+	attrs := []interface{}{"method", method, "code", code.String(), "duration", time.Since(start)}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+
+	slog.Log(ctx, level, "RPC", attrs...)
+}
+
+// contextStream is a ServerStream whose context carries the request ID.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
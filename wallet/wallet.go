@@ -3,9 +3,18 @@ package wallet
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/intellisoftalpin/cardano-wallet-backend/config"
 	"github.com/intellisoftalpin/cardano-wallet-backend/repo"
+	"github.com/intellisoftalpin/cardano-wallet-backend/secret"
 	walletPB "github.com/intellisoftalpin/proto/proto-gen/wallet"
 )
 
@@ -15,8 +24,8 @@ type Server struct {
 	TransactionRepo *repo.TransactionRepo
 }
 
-func NewServer(config *config.Config) *Server {
-	transactionRepo, err := repo.NewTransactionRepo(config)
+func NewServer(config *config.Config, secrets secret.Store) *Server {
+	transactionRepo, err := repo.NewTransactionRepo(config, secrets)
 	if err != nil {
 		panic(err)
 	}
@@ -27,7 +36,7 @@ func NewServer(config *config.Config) *Server {
 }
 
 func (s *Server) DecodeTransaction(ctx context.Context, in *walletPB.DecodeTransactionRequest) (*walletPB.DecodeTransactionResponse, error) {
-	tx, err := s.TransactionRepo.DecodeTransaction(in.Tx, in.PolicyId, in.AssetId)
+	tx, err := s.TransactionRepo.DecodeTransaction(ctx, in.Tx, in.PolicyId, in.AssetId)
 	if err != nil {
 		return nil, err
 	}
@@ -44,7 +53,7 @@ func (s *Server) DecodeTransaction(ctx context.Context, in *walletPB.DecodeTrans
 }
 
 func (s *Server) SubmitTransaction(ctx context.Context, in *walletPB.SubmitTransactionRequest) (*walletPB.SubmitTransactionResponse, error) {
-	txHash, err := s.TransactionRepo.SubmitExternalTransaction(in.Tx)
+	txHash, err := s.TransactionRepo.SubmitExternalTransaction(ctx, in.Tx)
 	if err != nil {
 		return nil, err
 	}
@@ -56,11 +65,25 @@ func (s *Server) SubmitTransaction(ctx context.Context, in *walletPB.SubmitTrans
 }
 
 func (s *Server) GetTransaction(ctx context.Context, in *walletPB.GetTransactionRequest) (*walletPB.GetTransactionResponse, error) {
-	rawTx, err := s.TransactionRepo.GetTransaction(in.TxHash, in.PolicyId, in.AssetId)
+	rawTx, err := s.TransactionRepo.GetTransaction(ctx, in.TxHash, in.PolicyId, in.AssetId)
 	if err != nil {
 		return nil, err
 	}
 
+	// the response has no fields for them, payouts report their finality in
+	// the header
+	if payout, ok := s.TransactionRepo.GetPayout(in.TxHash); ok {
+		header := metadata.Pairs(
+			"x-payout-status", payout.Status,
+			"x-payout-depth", strconv.FormatUint(payout.Depth, 10),
+			"x-payout-rollbacks", strconv.FormatUint(payout.Rollbacks, 10),
+		)
+
+		if err = grpc.SetHeader(ctx, header); err != nil {
+			return nil, err
+		}
+	}
+
 	return &walletPB.GetTransactionResponse{
 		// DecodedTx: decodedTx,
 		RawTx:  rawTx,
@@ -69,9 +92,9 @@ func (s *Server) GetTransaction(ctx context.Context, in *walletPB.GetTransaction
 }
 
 func (s *Server) CreateTransaction(ctx context.Context, in *walletPB.CreateTransactionRequest) (*walletPB.CreateTransactionResponse, error) {
-	rawTx, txHash, addressTo, transferAmount, assetAmount, assetDecimals, err := s.TransactionRepo.CreateTransaction(in.Tx, in.PolicyId, in.AssetId)
+	rawTx, txHash, addressTo, transferAmount, assetAmount, assetDecimals, err := s.TransactionRepo.CreateTransaction(ctx, in.Tx, in.PolicyId, in.AssetId)
 	if err != nil {
-		return nil, err
+		return nil, orderError(err)
 	}
 
 	return &walletPB.CreateTransactionResponse{
@@ -86,43 +109,66 @@ func (s *Server) CreateTransaction(ctx context.Context, in *walletPB.CreateTrans
 }
 
 func (s *Server) CheckTokenBalance(ctx context.Context, in *walletPB.CheckTokenBalanceRequest) (*walletPB.Empty, error) {
-	if err := s.TransactionRepo.CheckTokenBalance(in.Tx, in.PolicyId, in.AssetId); err != nil {
-		return nil, err
+	if err := s.TransactionRepo.CheckTokenBalance(ctx, in.Tx, in.PolicyId, in.AssetId); err != nil {
+		return nil, orderError(err)
 	}
 
 	return &walletPB.Empty{}, nil
 }
 
+// orderError reports orders whose quantities can't be priced as invalid
+// arguments.
+func orderError(err error) error {
+	if errors.Is(err, repo.ErrInvalidQuantity) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return err
+}
+
 // ----------------------------------------------------------------------
 
 func (s *Server) GetAllTokens(ctx context.Context, in *walletPB.Empty) (*walletPB.GetAllTokensResponse, error) {
-	tokens, err := s.TransactionRepo.GetAllTokens()
+	tokens, snapshot, err := s.TransactionRepo.GetAllTokens()
 	if err != nil {
 		return nil, err
 	}
 
+	if err = setSnapshotHeader(ctx, snapshot); err != nil {
+		return nil, err
+	}
+
 	var tokensPB []*walletPB.Token
+	var featured, remaining []string
 	for _, token := range tokens {
+		tokensPB = append(tokensPB, tokenPB(token))
 
-		tokensPB = append(tokensPB, &walletPB.Token{
-			AssetName: token.Metadata.Name,
-			PolicyId:  token.PolicyID,
-			AssetId:   token.AssetName,
-			Ticker:    token.Metadata.Ticker,
-			Logo:      token.Metadata.Logo,
-			Decimals:  token.Metadata.Decimals,
-			Address:   token.Address,
-			Price:     &walletPB.Price{Price: token.Price},
-
-			AssetUnit:     token.AssetUnit,
-			AssetQuantity: token.AssetQuantity,
-			AssetDecimals: token.AssetDecimals,
-			Fee:           token.Fee,
-			Deposit:       token.Deposit,
-			ProcessingFee: token.ProcessingFee,
-			TotalQuantity: token.TotalQuantity,
-			RewardAddress: token.RewardAddress,
-		})
+		tokenID := token.PolicyID + "." + token.AssetName
+
+		if token.Featured {
+			featured = append(featured, tokenID)
+		}
+
+		if token.RemainingLots != nil {
+			remaining = append(remaining, tokenID+"="+strconv.FormatUint(*token.RemainingLots, 10))
+		}
+	}
+
+	// the proto has no fields for them, featured tokens and the lots the sale
+	// caps still allow are listed in the header
+	header := metadata.MD{}
+	if len(featured) > 0 {
+		header.Set("x-catalog-featured", strings.Join(featured, ","))
+	}
+
+	if len(remaining) > 0 {
+		header.Set("x-sale-remaining-lots", strings.Join(remaining, ","))
+	}
+
+	if len(header) > 0 {
+		if err = grpc.SetHeader(ctx, header); err != nil {
+			return nil, err
+		}
 	}
 
 	return &walletPB.GetAllTokensResponse{
@@ -131,31 +177,72 @@ func (s *Server) GetAllTokens(ctx context.Context, in *walletPB.Empty) (*walletP
 }
 
 func (s *Server) GetToken(ctx context.Context, in *walletPB.TokenID) (*walletPB.GetTokenResponse, error) {
-	token, err := s.TransactionRepo.GetTokenData(in.TokenId)
+	token, snapshot, err := s.TransactionRepo.GetTokenData(in.TokenId)
 	if err != nil {
 		return nil, err
 	}
 
+	if err = setSnapshotHeader(ctx, snapshot); err != nil {
+		return nil, err
+	}
+
+	// the proto has no fields for them, the catalog attributes and the lots
+	// the sale caps still allow are in the header; the description may be any
+	// UTF-8, so it is sent binary
+	header := metadata.Pairs(
+		"x-catalog-featured", strconv.FormatBool(token.Featured),
+		"x-catalog-order", strconv.Itoa(token.Order),
+		"x-catalog-tags", strings.Join(token.Tags, ","),
+		"x-catalog-description-bin", token.Metadata.Description,
+	)
+
+	if token.RemainingLots != nil {
+		header.Set("x-sale-remaining-lots", strconv.FormatUint(*token.RemainingLots, 10))
+	}
+
+	if err = grpc.SetHeader(ctx, header); err != nil {
+		return nil, err
+	}
+
 	return &walletPB.GetTokenResponse{
-		Token: &walletPB.Token{
-			AssetName: token.Metadata.Name,
-			PolicyId:  token.PolicyID,
-			AssetId:   token.AssetName,
-			Ticker:    token.Metadata.Ticker,
-			Logo:      token.Metadata.Logo,
-			Decimals:  token.Metadata.Decimals,
-			Address:   token.Address,
-			Price:     &walletPB.Price{Price: token.Price},
-		},
+		Token: tokenPB(token),
 	}, nil
 }
 
+// tokenPB converts a catalog token, so GetAllTokens and GetToken return the
+// same fields.
+func tokenPB(token repo.CatalogToken) *walletPB.Token {
+	return &walletPB.Token{
+		AssetName: token.Metadata.Name,
+		PolicyId:  token.PolicyID,
+		AssetId:   token.AssetName,
+		Ticker:    token.Metadata.Ticker,
+		Logo:      token.Metadata.Logo,
+		Decimals:  token.Metadata.Decimals,
+		Address:   token.Address,
+		Price:     &walletPB.Price{Price: token.Price},
+
+		AssetUnit:     token.AssetUnit,
+		AssetQuantity: token.AssetQuantity,
+		AssetDecimals: token.AssetDecimals,
+		Fee:           token.Fee,
+		Deposit:       token.Deposit,
+		ProcessingFee: token.ProcessingFee,
+		TotalQuantity: token.TotalQuantity,
+		RewardAddress: token.RewardAddress,
+	}
+}
+
 func (s *Server) GetTokenPrice(ctx context.Context, in *walletPB.TokenID) (*walletPB.GetTokenPriceResponse, error) {
-	price, err := s.TransactionRepo.GetTokenPrice(in.TokenId)
+	price, snapshot, err := s.TransactionRepo.GetTokenPrice(in.TokenId)
 	if err != nil {
 		return nil, err
 	}
 
+	if err = setSnapshotHeader(ctx, snapshot); err != nil {
+		return nil, err
+	}
+
 	return &walletPB.GetTokenPriceResponse{
 		Price: &walletPB.Price{
 			Price: price,
@@ -163,8 +250,17 @@ func (s *Server) GetTokenPrice(ctx context.Context, in *walletPB.TokenID) (*wall
 	}, nil
 }
 
+// setSnapshotHeader reports the version and age of the catalog snapshot a
+// token response was served from.
+func setSnapshotHeader(ctx context.Context, snapshot repo.Snapshot) error {
+	return grpc.SetHeader(ctx, metadata.Pairs(
+		"x-snapshot-version", strconv.FormatUint(snapshot.Version, 10),
+		"x-snapshot-age-ms", strconv.FormatInt(snapshot.Age().Milliseconds(), 10),
+	))
+}
+
 func (s *Server) GetWalletNetworkInfo(ctx context.Context, in *walletPB.Empty) (*walletPB.GetWalletNetworkInfoResponse, error) {
-	networkInfo, err := s.TransactionRepo.GetWalletNetworkInfo()
+	networkInfo, err := s.TransactionRepo.GetWalletNetworkInfo(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -207,7 +303,7 @@ func (s *Server) GetWalletNetworkInfo(ctx context.Context, in *walletPB.Empty) (
 }
 
 func (s *Server) GetWalletsState(ctx context.Context, in *walletPB.Empty) (*walletPB.GetWalletsStateResponse, error) {
-	walletsState, err := s.TransactionRepo.GetWalletsState()
+	walletsState, err := s.TransactionRepo.GetWalletsState(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,294 @@
+// Package worker runs background jobs under a supervisor.
+//
+// Each job runs on its own interval, delayed by a random jitter so jobs
+// don't fire in lockstep. A job that panics is recovered and reported like
+// one that returned an error; the supervisor keeps running it. One-off jobs
+// started with Go get the same context and recovery. All jobs stop when the
+// context passed to Start is cancelled.
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job is one run of a worker. It should return soon after ctx is cancelled.
+type Job func(ctx context.Context) error
+
+// Status is what a worker last did.
+type Status struct {
+	Name     string `json:"name"`
+	Interval string `json:"interval"`
+	Jitter   string `json:"jitter"`
+	Running  bool   `json:"running"`
+
+	Runs   uint64 `json:"runs"`
+	Errors uint64 `json:"errors"`
+	Panics uint64 `json:"panics"`
+
+	LastRun      time.Time `json:"last_run"`
+	LastDuration string    `json:"last_duration"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastErrorAt  time.Time `json:"last_error_at,omitempty"`
+}
+
+type worker struct {
+	name     string
+	interval time.Duration
+	jitter   time.Duration
+	job      Job
+
+	status Status
+}
+
+type Supervisor struct {
+	mx      *sync.Mutex
+	workers []*worker
+	wg      *sync.WaitGroup
+
+	ctx     context.Context // set by Start
+	pending []task          // one-off jobs waiting for Start
+}
+
+// task is a one-off job.
+type task struct {
+	name string
+	job  Job
+}
+
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		mx: &sync.Mutex{},
+		wg: &sync.WaitGroup{},
+	}
+}
+
+// Add registers a job to run every interval plus up to jitter. Jobs added
+// after Start don't run.
+func (s *Supervisor) Add(name string, interval, jitter time.Duration, job Job) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.workers = append(s.workers, &worker{
+		name:     name,
+		interval: interval,
+		jitter:   jitter,
+		job:      job,
+		status: Status{
+			Name:     name,
+			Interval: interval.String(),
+			Jitter:   jitter.String(),
+		},
+	})
+}
+
+// Start runs every worker until ctx is cancelled.
+func (s *Supervisor) Start(ctx context.Context) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.ctx = ctx
+
+	for _, t := range s.pending {
+		s.goTask(t)
+	}
+	s.pending = nil
+
+	for _, w := range s.workers {
+		s.wg.Add(1)
+
+		go func(w *worker) {
+			defer s.wg.Done()
+			s.loop(ctx, w)
+		}(w)
+	}
+}
+
+// Go runs job once in the background under the context of Start, recovering
+// a panic like a worker's. Jobs started before Start wait for it. Wait waits
+// for them too.
+func (s *Supervisor) Go(name string, job Job) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.ctx == nil {
+		s.pending = append(s.pending, task{name: name, job: job})
+		return
+	}
+
+	s.goTask(task{name: name, job: job})
+}
+
+// goTask starts the one-off job. s.mx must be held.
+func (s *Supervisor) goTask(t task) {
+	ctx := s.ctx
+
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		if _, err := runJob(ctx, t.job); err != nil {
+			slog.ErrorContext(ctx, "Job failed", "job", t.name, "error", err)
+		}
+	}()
+}
+
+// Wait blocks until all workers stopped.
+func (s *Supervisor) Wait() {
+	s.wg.Wait()
+}
+
+// Status returns the status of every worker, ordered by name.
+func (s *Supervisor) Status() (statuses []Status) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, w := range s.workers {
+		statuses = append(statuses, w.status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+// loop runs the worker right after start, then every interval.
+func (s *Supervisor) loop(ctx context.Context, w *worker) {
+	for first := true; ; first = false {
+		delay := w.interval
+		if first {
+			delay = 0
+		}
+
+		if w.jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(w.jitter)))
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.run(ctx, w)
+	}
+}
+
+func (s *Supervisor) run(ctx context.Context, w *worker) {
+	s.mx.Lock()
+	w.status.Running = true
+	s.mx.Unlock()
+
+	start := time.Now()
+	panicked, err := runJob(ctx, w.job)
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	w.status.Running = false
+	w.status.Runs++
+	w.status.LastRun = start.UTC()
+	w.status.LastDuration = time.Since(start).String()
+
+	if panicked {
+		w.status.Panics++
+	}
+
+	if err != nil {
+		w.status.Errors++
+		w.status.LastError = err.Error()
+		w.status.LastErrorAt = time.Now().UTC()
+
+		slog.ErrorContext(ctx, "Worker failed", "worker", w.name, "error", err)
+	}
+}
+
+func runJob(ctx context.Context, job Job) (panicked bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, "Worker panic", "panic", r, "stack", string(debug.Stack()))
+
+			err = fmt.Errorf("panic: %v", r)
+			panicked = true
+		}
+	}()
+
+	return false, job(ctx)
+}
+
+// ----------------------------------------------------------------------
+
+// Parallel calls fn for every key, at most limit at a time, and returns the
+// errors it returned joined in one. A panic in fn is recovered as an error.
+func Parallel(ctx context.Context, limit int, keys []string, fn func(ctx context.Context, key string) error) error {
+	if limit < 1 {
+		limit = 1
+	}
+
+	sem := make(chan struct{}, limit)
+	wg := &sync.WaitGroup{}
+	mx := &sync.Mutex{}
+
+	var errs []error
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			_, err := runJob(ctx, func(ctx context.Context) error {
+				return fn(ctx, key)
+			})
+
+			if err != nil {
+				mx.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				mx.Unlock()
+			}
+		}(key)
+	}
+
+	wg.Wait()
+
+	return joinErrors(errs)
+}
+
+type multiError []error
+
+func (m multiError) Error() string {
+	msg := m[0].Error()
+	for _, err := range m[1:] {
+		msg += "; " + err.Error()
+	}
+
+	return msg
+}
+
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return multiError(errs)
+}